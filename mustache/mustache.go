@@ -388,6 +388,14 @@ func lookup(contextChain []interface{}, name string) reflect.Value {
 		return reflect.Value{}
 	}
 
+	// a trailing ? marks the name as optional to Finders, which may treat
+	// names they can't find as errors otherwise
+	optional := ""
+
+	if strings.HasSuffix(name, "?") {
+		name, optional = name[:len(name)-1], "?"
+	}
+
 	parts := strings.Split(name, ".")
 
 Outer:
@@ -397,7 +405,7 @@ Outer:
 		for ind, p := range parts {
 			for v.IsValid() {
 				if finder, ok := v.Interface().(Finder); ok {
-					return finder.Lookup(strings.Join(parts[ind:], "."))
+					return finder.Lookup(strings.Join(parts[ind:], ".") + optional)
 				}
 				typ := v.Type()
 				if n := v.Type().NumMethod(); n > 0 {
@@ -550,6 +558,34 @@ func (tmpl *Template) renderTemplate(contextChain []interface{}, buf io.Writer)
 	}
 }
 
+// Names of the vars and sections the template looks up in the context it
+// is rendered with. Names inside a section which isn't inverted may be
+// looked up on the values it iterates over instead, so they are left out
+func (tmpl *Template) Names() []string {
+	return names(tmpl.elems)
+}
+
+func names(elems []interface{}) []string {
+	ret := make([]string, 0)
+
+	for _, element := range elems {
+		switch elem := element.(type) {
+		case *varElement:
+			ret = append(ret, elem.name)
+		case *sectionElement:
+			ret = append(ret, elem.name)
+
+			if elem.inverted {
+				ret = append(ret, names(elem.elems)...)
+			}
+		case *Template:
+			ret = append(ret, elem.Names()...)
+		}
+	}
+
+	return ret
+}
+
 func (tmpl *Template) Render(context ...interface{}) string {
 	var buf bytes.Buffer
 	var contextChain []interface{}
@@ -640,6 +676,7 @@ func RenderFileInLayout(filename string, layoutFile string, context ...interface
 	return tmpl.RenderInLayout(layoutTmpl, context...)
 }
 
+// Values which look up names themselves. Optional names end with a ?
 type Finder interface {
 	Lookup(string) reflect.Value
 }
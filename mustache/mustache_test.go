@@ -3,6 +3,7 @@ package mustache
 import (
 	"os"
 	"path"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -230,6 +231,40 @@ func TestMalformed(t *testing.T) {
 	}
 }
 
+func TestNames(t *testing.T) {
+	tmpl, err := ParseString(`{{a}} {{{b.c}}} {{#list}}{{item}}{{/list}} {{^none}}{{d}}{{/none}} {{! comment }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if names := strings.Join(tmpl.Names(), " "); names != "a b.c list none d" {
+		t.Fatalf("unexpected names %q", names)
+	}
+}
+
+type recordingFinder []string
+
+func (f *recordingFinder) Lookup(name string) reflect.Value {
+	*f = append(*f, name)
+	return reflect.ValueOf("x")
+}
+
+func TestOptionalNames(t *testing.T) {
+	if out := Render(`{{a?}} {{b.c?}}`, map[string]interface{}{"a": 1, "b": map[string]string{"c": "2"}}); out != "1 2" {
+		t.Fatalf("unexpected output %q", out)
+	}
+
+	f := &recordingFinder{}
+
+	if out := Render(`{{a?}} {{b}}`, f); out != "x x" {
+		t.Fatalf("unexpected output %q", out)
+	}
+
+	if names := strings.Join(*f, " "); names != "a? b" {
+		t.Fatalf("unexpected lookups %q", names)
+	}
+}
+
 type LayoutTest struct {
 	layout   string
 	tmpl     string
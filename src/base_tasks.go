@@ -1,9 +1,16 @@
 package src
 
 import (
+	"context"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
 	"os/exec"
 	"reflect"
+	"strings"
+	"sync"
+	"time"
 )
 
 type baseTask struct {
@@ -13,6 +20,7 @@ type baseTask struct {
 	varName     string
 	export      []map[string]interface{}
 	env         *Env
+	pos         pos
 }
 
 func (t *baseTask) Name() string {
@@ -39,13 +47,19 @@ func (t *baseTask) Env() *Env {
 	return t.env
 }
 
+// where the task was declared
+func (t *baseTask) position() pos {
+	return t.pos
+}
+
 func (t *baseTask) set(name, description, typ, varName string, export []map[string]interface{}, env *Env) {
 
 }
 
 type funcTask struct {
 	*baseTask
-	fn func(r RunContext) error
+	fn   func(r RunContext) error
+	args reflect.Value
 }
 
 func (t *funcTask) Run(r RunContext) error {
@@ -55,6 +69,7 @@ func (t *funcTask) Run(r RunContext) error {
 func proxyTask(task Task, args reflect.Value) *funcTask {
 	return &funcTask{
 		baseTask: &baseTask{},
+		args:     args,
 		fn: func(r RunContext) error {
 			env := r.Env()
 			env.addParent(task.Env())
@@ -63,7 +78,16 @@ func proxyTask(task Task, args reflect.Value) *funcTask {
 				keys := args.MapKeys()
 
 				for _, k := range keys {
-					env.SetVar(k.String(), args.MapIndex(k).Elem().Interface())
+					v := args.MapIndex(k).Elem().Interface()
+
+					// SetVar renders leniently, so check the value first
+					if r.Strict() {
+						if _, err := render(r, v); err != nil {
+							return err
+						}
+					}
+
+					env.SetVar(k.String(), v)
 				}
 			}
 
@@ -74,43 +98,167 @@ func proxyTask(task Task, args reflect.Value) *funcTask {
 	}
 }
 
+const waitDelay = 100 * time.Millisecond
+
+var defaultInterpreter = []string{"sh", "-c"}
+
+// runs a command. By default the templated script is passed as the last
+// arg of the interpreter, when file is set it is written to a temp file
+// instead, and when argv is set each arg is templated and run directly
+// without any interpreter
 type shellTask struct {
 	*baseTask
-	cmd  string
-	args []string
+	script string
+	file   bool
+	argv   []string
 }
 
 func (t *shellTask) Run(r RunContext) error {
-	cmd := template(t.cmd, r.Env()).(string)
-	args := make([]string, len(t.args))
+	var argv []string
+
+	if t.argv != nil {
+		argv = make([]string, len(t.argv))
+
+		for i, arg := range t.argv {
+			v, err := render(r, arg)
+
+			if err != nil {
+				return err
+			}
+
+			argv[i] = v.(string)
+		}
+	} else {
+		argv = append(argv, r.Interpreter()...)
+	}
+
+	v, err := render(r, t.script)
+
+	if err != nil {
+		return err
+	}
+
+	script := v.(string)
+
+	Debugf("[SHELL] [ENV=%s] %s %s", r.Env().Id(), argv, script)
+
+	if rt := r.Runtime(); rt.DryRun {
+		switch {
+		case t.argv != nil:
+			rt.printDry(r.Depth(), shellQuote(argv))
+		case t.file:
+			rt.printDry(r.Depth(), shellQuote(scriptInterpreter(argv))+" <<'SCRIPT'")
+
+			for _, line := range strings.Split(strings.TrimRight(script, "\n"), "\n") {
+				rt.printDry(r.Depth()+1, line)
+			}
+
+			rt.printDry(r.Depth(), "SCRIPT")
+		default:
+			rt.printDry(r.Depth(), shellQuote(append(argv, script)))
+		}
+
+		return nil
+	}
+
+	switch {
+	case t.argv != nil:
+	case t.file:
+		f, err := writeScript(script)
+
+		if err != nil {
+			return err
+		}
+
+		defer os.Remove(f)
 
-	for i, _ := range args {
-		args[i] = template(t.args[i], r.Env()).(string)
+		argv = append(scriptInterpreter(argv), f)
+	default:
+		argv = append(argv, script)
 	}
 
-	Debugf("[SHELL] [ENV=%s] %s %s", r.Env().Id(), cmd, args)
-	c := exec.Command(cmd, args...)
+	c := exec.CommandContext(r.Context(), argv[0], argv[1:]...)
+
+	// don't wait on pipes held open by orphaned children once the
+	// command has been cancelled
+	c.WaitDelay = waitDelay
+
+	// a new process group detaches the command from the terminal and
+	// from the signals sent to taskies, so only use one when a timeout
+	// may have to kill the command's children
+	if _, ok := r.Context().Deadline(); ok {
+		killGroupOnCancel(c)
+	}
 
 	c.Stdin = r.In()
 	c.Stdout = r.Out()
 	c.Stderr = r.Err()
+	c.Dir = r.Dir()
+
+	environ := r.Environ()
+
+	if r.Runtime().ExportVars {
+		environ = append(r.Env().exportedVars(), environ...)
+	}
+
+	if len(environ) > 0 {
+		c.Env = append(os.Environ(), environ...)
+	}
 
 	return c.Run()
 }
 
+// the interpreter of a script file, which is passed as a path rather
+// than with -c
+func scriptInterpreter(interp []string) []string {
+	if l := len(interp); l > 1 && interp[l-1] == "-c" {
+		return interp[:l-1]
+	}
+
+	return interp
+}
+
+func writeScript(script string) (string, error) {
+	f, err := ioutil.TempFile("", "taskies_script")
+
+	if err != nil {
+		return "", err
+	}
+
+	_, err = f.WriteString(script)
+
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
+}
+
 type compositeTask struct {
 	*baseTask
-	tasks []Task
+	tasks           []Task
+	continueOnError bool
 }
 
 func (t *compositeTask) Run(r RunContext) error {
+	errs := make(multiError, 0)
+
 	for _, tt := range t.tasks {
 		if err := r.Run(tt); err != nil {
-			return err
+			if !t.continueOnError || r.Context().Err() != nil {
+				return err
+			}
+
+			errs = append(errs, err)
 		}
 	}
 
-	return nil
+	return errs.err()
 }
 
 type pipeTask struct {
@@ -119,6 +267,10 @@ type pipeTask struct {
 }
 
 func (t *pipeTask) Run(r RunContext) error {
+	if rt := r.Runtime(); rt.DryRun {
+		return t.dryRun(r)
+	}
+
 	ch := make(chan error)
 	l := len(t.tasks)
 	in := r.In()
@@ -164,3 +316,122 @@ func (t *pipeTask) Run(r RunContext) error {
 
 	return nil
 }
+
+// print the stages one after the other in pipe order, with a | between
+// them, as running them at once would print them in any order
+func (t *pipeTask) dryRun(r RunContext) error {
+	for i, t := range t.tasks {
+		if i > 0 {
+			r.Runtime().printDry(r.Depth(), "|")
+		}
+
+		if err := r.Run(t); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type parallelTask struct {
+	*baseTask
+	tasks           []Task
+	max             int
+	continueOnError bool
+}
+
+func (t *parallelTask) Run(r RunContext) error {
+	max := t.max
+
+	if max <= 0 {
+		max = r.Runtime().Jobs
+	}
+
+	if max <= 0 || max > len(t.tasks) {
+		max = len(t.tasks)
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	r2 := r.Clone(nil, &syncWriter{w: r.Out()}, &syncWriter{w: r.Err()}, nil).WithContext(ctx)
+
+	var (
+		wg    sync.WaitGroup
+		once  sync.Once
+		first error
+		l     sync.Mutex
+	)
+
+	errs := make(multiError, 0)
+
+	sem := make(chan bool, max)
+
+	for _, tt := range t.tasks {
+		sem <- true
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+
+		go func(tt Task) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			err := r2.Run(tt)
+
+			if err == nil {
+				return
+			}
+
+			if t.continueOnError {
+				l.Lock()
+				errs = append(errs, err)
+				l.Unlock()
+
+				return
+			}
+
+			// the first failure cancels every task still running
+			once.Do(func() {
+				first = err
+				cancel()
+			})
+		}(tt)
+	}
+
+	wg.Wait()
+
+	if first != nil {
+		return first
+	}
+
+	return errs.err()
+}
+
+// the failures of a block which kept going after a step failed
+type multiError []error
+
+func (m multiError) Error() string {
+	msgs := make([]string, len(m))
+
+	for i, err := range m {
+		msgs[i] = err.Error()
+	}
+
+	return fmt.Sprintf("%d steps failed: %s", len(m), strings.Join(msgs, "; "))
+}
+
+func (m multiError) Unwrap() []error {
+	return m
+}
+
+func (m multiError) err() error {
+	if len(m) == 0 {
+		return nil
+	}
+
+	return m
+}
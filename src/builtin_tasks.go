@@ -0,0 +1,557 @@
+package src
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dimerica-industries/taskies/mustache"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// run items implemented in Go rather than by running a command. Their
+// args are templated before the builtin sees them
+var builtins = map[string]func(RunContext, interface{}) error{
+	"mkdir":    builtinMkdir,
+	"copy":     builtinCopy,
+	"move":     builtinMove,
+	"remove":   builtinRemove,
+	"write":    builtinWrite,
+	"template": builtinTemplate,
+}
+
+// Error returned by the builtin file tasks
+type FileError struct {
+	Op   string
+	Path string
+	Dest string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	if e.Dest != "" {
+		return fmt.Sprintf("%s %s -> %s: %s", e.Op, e.Path, e.Dest, e.Err)
+	}
+
+	return fmt.Sprintf("%s %s: %s", e.Op, e.Path, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+func fileError(op, path, dest string, err error) error {
+	var pe *os.PathError
+	var le *os.LinkError
+
+	// the op and paths are already part of the FileError
+	if errors.As(err, &pe) {
+		err = pe.Err
+	} else if errors.As(err, &le) {
+		err = le.Err
+	}
+
+	return &FileError{op, path, dest, err}
+}
+
+type builtinTask struct {
+	*baseTask
+	args reflect.Value
+	fn   func(RunContext, interface{}) error
+}
+
+func (t *builtinTask) Run(r RunContext) error {
+	var (
+		args interface{}
+		err  error
+	)
+
+	if t.args.IsValid() {
+		if args, err = render(r, t.args.Interface()); err != nil {
+			return err
+		}
+	}
+
+	Debugf("[BUILTIN] [ENV=%s] %s %#v", r.Env().Id(), t.Type(), args)
+
+	return t.fn(r, args)
+}
+
+// report what a builtin did on OUT, or only what it would do in a dry run
+func report(r RunContext, line string, fn func() error) error {
+	if rt := r.Runtime(); rt.DryRun {
+		rt.printDry(r.Depth(), line)
+		return nil
+	}
+
+	if err := fn(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintln(r.Out(), line)
+
+	return err
+}
+
+func builtinMkdir(r RunContext, args interface{}) error {
+	paths, err := builtinPaths("mkdir", args)
+
+	if err != nil {
+		return err
+	}
+
+	for _, p := range paths {
+		err := report(r, "mkdir "+p, func() error {
+			if err := os.MkdirAll(joinDir(r.Dir(), p), 0755); err != nil {
+				return fileError("mkdir", p, "", err)
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func builtinRemove(r RunContext, args interface{}) error {
+	globs, err := builtinPaths("remove", args)
+
+	if err != nil {
+		return err
+	}
+
+	for _, g := range globs {
+		// an unset var can leave nothing but a slash or nothing at all
+		if strings.TrimSpace(g) == "" {
+			return fileError("remove", g, "", unsafeRemove)
+		}
+
+		matches, err := globIn(r.Dir(), g)
+
+		if err != nil {
+			return fileError("remove", g, "", err)
+		}
+
+		for _, m := range matches {
+			if err := checkRemove(r.Dir(), m); err != nil {
+				return fileError("remove", m, "", err)
+			}
+
+			err := report(r, "remove "+m, func() error {
+				if err := os.RemoveAll(joinDir(r.Dir(), m)); err != nil {
+					return fileError("remove", m, "", err)
+				}
+
+				return nil
+			})
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+var unsafeRemove = errors.New("refusing to remove the root directory, a directory directly below it or the task directory")
+
+// refuse to remove p when it is the root of a volume or directly below
+// it, or when it is dir or contains it
+func checkRemove(dir, p string) error {
+	abs, err := filepath.Abs(joinDir(dir, p))
+
+	if err != nil {
+		return err
+	}
+
+	if parent := filepath.Dir(abs); parent == abs || filepath.Dir(parent) == parent {
+		return unsafeRemove
+	}
+
+	base, err := filepath.Abs(joinDir(dir, "."))
+
+	if err != nil {
+		return err
+	}
+
+	if contains(abs, base) {
+		return unsafeRemove
+	}
+
+	return nil
+}
+
+// whether p is dir or below it, both being absolute
+func contains(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func builtinCopy(r RunContext, args interface{}) error {
+	return transfer(r, "copy", args, copyPath)
+}
+
+func builtinMove(r RunContext, args interface{}) error {
+	return transfer(r, "move", args, func(src, dest string) error {
+		err := os.Rename(src, dest)
+
+		// rename can't move across file systems
+		if errors.Is(err, syscall.EXDEV) {
+			if err = copyPath(src, dest); err == nil {
+				err = os.RemoveAll(src)
+			}
+		}
+
+		return err
+	})
+}
+
+// copy or move every file matched by the src globs to dest. dest is
+// taken to be a directory when it ends with a slash, already is one, or
+// more than one file matched
+func transfer(r RunContext, op string, args interface{}, fn func(src, dest string) error) error {
+	m, ok := args.(map[string]interface{})
+
+	if !ok || m["src"] == nil || m["dest"] == nil {
+		return fmt.Errorf("%s expects a map with src and dest", op)
+	}
+
+	globs, err := builtinPaths(op, m["src"])
+
+	if err != nil {
+		return err
+	}
+
+	dest := fmt.Sprintf("%v", m["dest"])
+	srcs := make([]string, 0)
+
+	for _, g := range globs {
+		matches, err := globIn(r.Dir(), g)
+
+		if err != nil {
+			return fileError(op, g, dest, err)
+		}
+
+		if len(matches) == 0 {
+			return fileError(op, g, dest, os.ErrNotExist)
+		}
+
+		srcs = append(srcs, matches...)
+	}
+
+	info, err := os.Stat(joinDir(r.Dir(), dest))
+	intoDir := len(srcs) > 1 || strings.HasSuffix(dest, "/") || (err == nil && info.IsDir())
+	targets := make([]string, len(srcs))
+
+	// check every target before touching anything
+	for i, src := range srcs {
+		targets[i] = dest
+
+		if intoDir {
+			targets[i] = filepath.Join(dest, filepath.Base(src))
+		}
+
+		if err := checkTransfer(r.Dir(), src, targets[i]); err != nil {
+			return fileError(op, src, targets[i], err)
+		}
+	}
+
+	for i, src := range srcs {
+		target := targets[i]
+
+		err := report(r, op+" "+src+" -> "+target, func() error {
+			if err := os.MkdirAll(filepath.Dir(joinDir(r.Dir(), target)), 0755); err != nil {
+				return fileError(op, src, target, err)
+			}
+
+			if err := fn(joinDir(r.Dir(), src), joinDir(r.Dir(), target)); err != nil {
+				return fileError(op, src, target, err)
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var unsafeTransfer = errors.New("refusing to copy or move a path to itself or below it")
+
+// refuse to copy or move src to dest when dest is src or below it, as a
+// copy would then walk the files it creates
+func checkTransfer(dir, src, dest string) error {
+	from, err := filepath.Abs(joinDir(dir, src))
+
+	if err != nil {
+		return err
+	}
+
+	to, err := filepath.Abs(joinDir(dir, dest))
+
+	if err != nil {
+		return err
+	}
+
+	if contains(from, to) {
+		return unsafeTransfer
+	}
+
+	return nil
+}
+
+func builtinWrite(r RunContext, args interface{}) error {
+	m, ok := args.(map[string]interface{})
+
+	if !ok || m["path"] == nil {
+		return fmt.Errorf("write expects a map with path and content")
+	}
+
+	path := fmt.Sprintf("%v", m["path"])
+	content := ""
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+
+	if c, ok := m["content"]; ok {
+		content = fmt.Sprintf("%v", c)
+	}
+
+	if a, ok := m["append"]; ok {
+		b, err := strconv.ParseBool(fmt.Sprintf("%v", a))
+
+		if err != nil {
+			return fmt.Errorf("append of write must be a boolean")
+		}
+
+		if b {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+	}
+
+	return report(r, "write "+path, func() error {
+		p := joinDir(r.Dir(), path)
+
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return fileError("write", path, "", err)
+		}
+
+		f, err := os.OpenFile(p, flags, 0644)
+
+		if err != nil {
+			return fileError("write", path, "", err)
+		}
+
+		_, err = io.WriteString(f, content)
+
+		if err2 := f.Close(); err == nil {
+			err = err2
+		}
+
+		if err != nil {
+			return fileError("write", path, "", err)
+		}
+
+		return nil
+	})
+}
+
+// render a mustache file, or every file in a directory tree along with
+// their names, against the current env and any vars passed to it
+func builtinTemplate(r RunContext, args interface{}) error {
+	m, ok := args.(map[string]interface{})
+
+	if !ok || m["src"] == nil || m["dest"] == nil {
+		return fmt.Errorf("template expects a map with src, dest and optional vars")
+	}
+
+	src := fmt.Sprintf("%v", m["src"])
+	dest := fmt.Sprintf("%v", m["dest"])
+	env := r.Env().Child()
+
+	if vars, ok := m["vars"].(map[string]interface{}); ok {
+		// the args are rendered already, so SetVar would render the
+		// vars a second time and leniently
+		for k, v := range vars {
+			env.vars.Set(k, v)
+		}
+	} else if m["vars"] != nil {
+		return fmt.Errorf("vars of template must be a map")
+	}
+
+	info, err := os.Stat(joinDir(r.Dir(), src))
+
+	if err != nil {
+		return fileError("template", src, dest, err)
+	}
+
+	if !info.IsDir() {
+		if dinfo, err := os.Stat(joinDir(r.Dir(), dest)); strings.HasSuffix(dest, "/") || (err == nil && dinfo.IsDir()) {
+			name, err := renderValue(filepath.Base(src), env, r.Strict())
+
+			if err != nil {
+				return err
+			}
+
+			dest = filepath.Join(dest, name.(string))
+		}
+
+		return renderFile(r, env, src, dest, info.Mode().Perm())
+	}
+
+	return filepath.Walk(joinDir(r.Dir(), src), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return fileError("template", src, dest, err)
+		}
+
+		rel, err := filepath.Rel(joinDir(r.Dir(), src), path)
+
+		if err != nil {
+			return err
+		}
+
+		name, err := renderValue(rel, env, r.Strict())
+
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dest, name.(string))
+
+		if info.IsDir() {
+			if r.Runtime().DryRun {
+				return nil
+			}
+
+			if err := os.MkdirAll(joinDir(r.Dir(), target), 0755); err != nil {
+				return fileError("template", filepath.Join(src, rel), target, err)
+			}
+
+			return nil
+		}
+
+		return renderFile(r, env, filepath.Join(src, rel), target, info.Mode().Perm())
+	})
+}
+
+func renderFile(r RunContext, env *Env, src, dest string, mode os.FileMode) error {
+	return report(r, "template "+src+" -> "+dest, func() error {
+		tmpl, err := mustache.ParseFile(joinDir(r.Dir(), src))
+
+		if err != nil {
+			return fileError("template", src, dest, err)
+		}
+
+		out, err := renderTemplate(tmpl, env, r.Strict())
+
+		if err != nil {
+			return err
+		}
+
+		p := joinDir(r.Dir(), dest)
+
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return fileError("template", src, dest, err)
+		}
+
+		if err := ioutil.WriteFile(p, []byte(out), mode); err != nil {
+			return fileError("template", src, dest, err)
+		}
+
+		return nil
+	})
+}
+
+// the templated args of a builtin taking a path or a list of paths
+func builtinPaths(op string, args interface{}) ([]string, error) {
+	switch v := args.(type) {
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		paths := make([]string, len(v))
+
+		for i, p := range v {
+			paths[i] = fmt.Sprintf("%v", p)
+		}
+
+		return paths, nil
+	}
+
+	return nil, fmt.Errorf("%s expects a path or a list of paths", op)
+}
+
+// files matching glob relative to dir, sorted and relative to dir too
+func globIn(dir, glob string) ([]string, error) {
+	matches, err := filepath.Glob(joinDir(dir, glob))
+
+	if err != nil || dir == "" || filepath.IsAbs(glob) {
+		return matches, err
+	}
+
+	for i, m := range matches {
+		if rel, err := filepath.Rel(dir, m); err == nil {
+			matches[i] = rel
+		}
+	}
+
+	return matches, nil
+}
+
+// copy a file or a whole directory tree, keeping file modes
+func copyPath(src, dest string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dest, rel)
+
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm())
+		}
+
+		return copyFile(path, target, info.Mode().Perm())
+	})
+}
+
+func copyFile(src, dest string, mode os.FileMode) error {
+	in, err := os.Open(src)
+
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+
+	if err2 := out.Close(); err == nil {
+		err = err2
+	}
+
+	return err
+}
@@ -0,0 +1,559 @@
+package src
+
+import (
+	"fmt"
+	"github.com/dimerica-industries/taskies/mustache"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// vars set by taskies itself while running tasks
+var runtimeVars = []string{
+	"LAST", "TASKS", "OUT", "ERR", "ITEM", "KEY", "INDEX", "RESULTS", "ERROR",
+	"EXIT_CODE", "SUCCESS", "SKIPPED", "ATTEMPT", "STARTED_AT", "FINISHED_AT",
+	"DURATION",
+}
+
+// Look for problems in the Taskies file at path and in the files it
+// includes without running anything: references to tasks which don't
+// exist or aren't defined yet, template vars nothing sets, private tasks
+// nothing uses, include cycles, unknown keys taken to be tasks and badly
+// named tasks. Problems are sorted by file and position.
+//
+// Vars which exported tasks use but nothing sets may still be given on the
+// command line, as in taskies Hello -name bob, so they are returned as
+// hints rather than problems. Tasks declaring params: only accept those
+// from the command line, and private tasks can't be run from it
+func Check(path string) (problems, hints []*SourceError) {
+	c := &checker{
+		files: make(map[string]*checkFile),
+		vars:  make(map[string]bool),
+		used:  make(map[*checkTask]bool),
+		seen:  make(map[string]bool),
+	}
+
+	for _, name := range runtimeVars {
+		c.vars[name] = true
+	}
+
+	c.load(path, pos{})
+
+	for _, f := range c.order {
+		c.checkFile(f)
+	}
+
+	for _, f := range c.order {
+		for _, t := range f.tasks {
+			if !exported(t.name) && !c.used[t] {
+				c.report(t.pos, "private task \"%s\" is never used", t.name)
+			}
+		}
+	}
+
+	sortErrors(c.problems)
+	sortErrors(c.hints)
+
+	return c.problems, c.hints
+}
+
+func sortErrors(errs []*SourceError) {
+	sort.SliceStable(errs, func(i, j int) bool {
+		a, b := errs[i], errs[j]
+
+		if a.File != b.File {
+			return a.File < b.File
+		}
+
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+
+		return a.Col < b.Col
+	})
+}
+
+type checker struct {
+	files map[string]*checkFile
+	order []*checkFile
+	stack includeStack
+
+	// names of every var set anywhere, vars being dynamically scoped
+	vars map[string]bool
+
+	used     map[*checkTask]bool
+	seen     map[string]bool
+	problems []*SourceError
+	hints    []*SourceError
+
+	// whether the templates being checked belong to a task which may get
+	// any var from the command line, being exported without params:
+	cliVars bool
+}
+
+type checkFile struct {
+	path    string
+	ast     *ast
+	tasks   map[string]*checkTask
+	aliases map[string]*checkAlias
+}
+
+// a task defined by the instruction at index in its file
+type checkTask struct {
+	*defineTask
+	index int
+}
+
+// a file included by the instruction at index
+type checkAlias struct {
+	file  *checkFile
+	index int
+}
+
+// what a task name resolves to
+const (
+	refFound = iota
+	refMissing
+	refLater
+	refPrivate
+)
+
+func (c *checker) report(p pos, format string, args ...interface{}) {
+	c.add(&c.problems, p, format, args...)
+}
+
+func (c *checker) hint(p pos, format string, args ...interface{}) {
+	c.add(&c.hints, p, format, args...)
+}
+
+func (c *checker) add(errs *[]*SourceError, p pos, format string, args ...interface{}) {
+	err := &SourceError{p.file, p.line, p.col, fmt.Errorf(format, args...)}
+
+	// the same template may be checked more than once
+	if c.seen[err.Error()] {
+		return
+	}
+
+	c.seen[err.Error()] = true
+	*errs = append(*errs, err)
+}
+
+// parse the file at path and everything it includes, collecting the
+// tasks and vars they define
+func (c *checker) load(path string, from pos) *checkFile {
+	id, err := filepath.Abs(path)
+
+	if err != nil {
+		c.report(from, "%s", err)
+		return nil
+	}
+
+	if !from.known() {
+		from = pos{file: id}
+	}
+
+	if err := c.stack.enter(id); err != nil {
+		c.report(from, "%s", err)
+		return nil
+	}
+
+	defer c.stack.leave()
+
+	if f, ok := c.files[id]; ok {
+		return f
+	}
+
+	raw, err := ioutil.ReadFile(id)
+
+	if err != nil {
+		c.report(from, "%s", err)
+		return nil
+	}
+
+	ast, err := parseFile(id, raw)
+
+	if err != nil {
+		if se, ok := err.(*SourceError); ok {
+			c.problems = append(c.problems, se)
+		} else {
+			c.report(pos{file: id}, "%s", err)
+		}
+
+		return nil
+	}
+
+	f := &checkFile{
+		path:    id,
+		ast:     ast,
+		tasks:   make(map[string]*checkTask),
+		aliases: make(map[string]*checkAlias),
+	}
+
+	c.files[id] = f
+
+	for i, ins := range ast.instructions {
+		switch ins := ins.(type) {
+		case *defineTask:
+			c.define(f, ins, i)
+		case *includeNs:
+			c.include(f, ins, i)
+		case *setVar:
+			c.setVars(ins.vars)
+		case *runTasks:
+			c.runVars(ins)
+		}
+	}
+
+	c.order = append(c.order, f)
+
+	return f
+}
+
+func (c *checker) include(f *checkFile, ins *includeNs, index int) {
+	for _, n := range ins.ns {
+		includes, err := expandInclude(filepath.Dir(f.path), n)
+
+		if err != nil {
+			c.report(ins.pos, "%s", err)
+			continue
+		}
+
+		for _, n2 := range includes {
+			if f2 := c.load(n2.path, ins.pos); f2 != nil {
+				f.aliases[n2.alias] = &checkAlias{f2, index}
+				c.vars[firstName(n2.alias)] = true
+			}
+		}
+	}
+}
+
+func (c *checker) define(f *checkFile, t *defineTask, index int) {
+	switch {
+	case t.name == "":
+		c.report(t.pos, "task has no name")
+		return
+	case strings.ContainsAny(t.name, ".:"):
+		c.report(t.pos, "task name \"%s\" can't contain \".\" or \":\", which qualify the tasks of included files", t.name)
+	case commandTypes[t.name]:
+		c.report(t.pos, "task \"%s\" can't be run by other tasks, the builtin \"%s\" is run instead", t.name, t.name)
+	}
+
+	if prev, ok := f.tasks[t.name]; ok {
+		c.report(t.pos, "task \"%s\" is already defined at line %d", t.name, prev.pos.line)
+		return
+	}
+
+	for name, prev := range f.tasks {
+		if strings.EqualFold(name, t.name) {
+			c.report(t.pos, "task \"%s\" only differs by case from task \"%s\" at line %d", t.name, name, prev.pos.line)
+		}
+	}
+
+	f.tasks[t.name] = &checkTask{t, index}
+	c.vars[firstName(t.name)] = true
+	c.setVars(t.set.vars)
+
+	for _, p := range t.opts.params {
+		c.vars[p.name] = true
+	}
+
+	for _, rts := range []*runTasks{t.runList, t.opts.onError, t.opts.finally} {
+		if rts != nil {
+			c.runVars(rts)
+		}
+	}
+}
+
+func (c *checker) setVars(vars map[string]interface{}) {
+	for k := range vars {
+		if !strings.Contains(k, "{{") {
+			c.vars[firstName(k)] = true
+		}
+	}
+}
+
+// vars set by run items, either as their result or as the args of the
+// task they run
+func (c *checker) runVars(rts *runTasks) {
+	for _, rt := range rts.tasks {
+		if rt.varName != "" {
+			c.vars[firstName(rt.varName)] = true
+		}
+
+		if !commandTypes[rt.task] && rt.args.Kind() == reflect.Map {
+			for _, k := range rt.args.MapKeys() {
+				c.vars[k.String()] = true
+			}
+		}
+	}
+}
+
+func (c *checker) checkFile(f *checkFile) {
+	for i, ins := range f.ast.instructions {
+		switch ins := ins.(type) {
+		case *defineTask:
+			owner := fmt.Sprintf("task \"%s\"", ins.name)
+			c.cliVars = exported(ins.name) && ins.opts.params == nil
+
+			for _, rts := range []*runTasks{ins.runList, ins.opts.onError, ins.opts.finally} {
+				if rts != nil {
+					c.checkRuns(f, rts, i, owner)
+				}
+			}
+
+			for _, dep := range ins.opts.deps {
+				c.checkRef(f, ins.opts.depsPos, dep, -1, owner+" depends on")
+			}
+
+			c.checkOptions(ins.pos, ins.opts)
+			c.checkTemplates(ins.set.pos, ins.set.vars)
+			c.cliVars = false
+		case *runTasks:
+			c.checkRuns(f, ins, i, "run")
+		case *setVar:
+			c.checkTemplates(ins.pos, ins.vars)
+		case *fileEnv:
+			c.checkTemplates(ins.pos, ins.vars)
+		}
+	}
+}
+
+// check the run items of the instruction at index, owner being how
+// problems refer to the instruction
+func (c *checker) checkRuns(f *checkFile, rts *runTasks, index int, owner string) {
+	for _, rt := range rts.tasks {
+		c.checkOptions(rt.pos, rt.opts)
+
+		if rt.args.IsValid() {
+			c.checkTemplates(rt.pos, rt.args.Interface())
+		}
+
+		if commandTypes[rt.task] {
+			continue
+		}
+
+		if t, _ := c.resolve(f, rt.task, -1); t == nil && rt.implicit {
+			c.unknownKey(rt, owner)
+			continue
+		}
+
+		c.checkRef(f, rt.pos, rt.task, index, owner+" references")
+	}
+}
+
+func (c *checker) checkRef(f *checkFile, p pos, name string, index int, ref string) {
+	t, kind := c.resolve(f, name, index)
+
+	if t != nil {
+		c.used[t] = true
+	}
+
+	switch kind {
+	case refMissing:
+		c.report(p, "%s unknown task \"%s\"", ref, name)
+	case refLater:
+		c.report(p, "%s task \"%s\" before it is defined", ref, name)
+	case refPrivate:
+		c.report(p, "%s private task \"%s\", only tasks starting with an uppercase letter can be used by other files", ref, name)
+	}
+}
+
+// resolve a task name used by the instruction at index of f, or by
+// something such as deps which may use tasks defined anywhere in f when
+// index is -1
+func (c *checker) resolve(f *checkFile, name string, index int) (*checkTask, int) {
+	if t, ok := f.tasks[name]; ok {
+		if index >= 0 && t.index >= index {
+			return t, refLater
+		}
+
+		return t, refFound
+	}
+
+	aliases := make([]string, 0, len(f.aliases))
+
+	for alias := range f.aliases {
+		aliases = append(aliases, alias)
+	}
+
+	// nested aliases such as "lib.sub" win over "lib"
+	sort.Slice(aliases, func(i, j int) bool {
+		return len(aliases[i]) > len(aliases[j])
+	})
+
+	for _, alias := range aliases {
+		a := f.aliases[alias]
+
+		if alias == "" || !strings.HasPrefix(name, alias+".") {
+			continue
+		}
+
+		t, kind := c.resolve(a.file, name[len(alias)+1:], -1)
+
+		if t == nil {
+			continue
+		}
+
+		if index >= 0 && a.index >= index {
+			return t, refLater
+		}
+
+		if kind == refFound && !exported(t.name) {
+			return t, refPrivate
+		}
+
+		return t, kind
+	}
+
+	return nil, refMissing
+}
+
+func (c *checker) unknownKey(rt *runTask, owner string) {
+	known := taskKeys
+	msg := fmt.Sprintf("key \"%s\" of %s is neither a setting nor a known task", rt.task, owner)
+
+	if owner == "run" {
+		known = knownInstructions
+		msg = fmt.Sprintf("\"%s\" is neither an instruction nor a known task", rt.task)
+	}
+
+	keys := make([]string, 0, len(known)+len(commandTypes))
+
+	for k := range known {
+		keys = append(keys, k)
+	}
+
+	for k := range commandTypes {
+		keys = append(keys, k)
+	}
+
+	// sorted for ties to always suggest the same key
+	sort.Strings(keys)
+
+	if s := closest(rt.task, keys); s != "" {
+		msg += fmt.Sprintf(", did you mean \"%s\"?", s)
+	}
+
+	c.report(rt.pos, "%s", msg)
+}
+
+func (c *checker) checkOptions(p pos, opts *taskOptions) {
+	c.checkTemplates(p, opts.dir)
+	c.checkTemplates(p, opts.env)
+	c.checkTemplates(p, opts.each)
+	c.checkTemplates(p, opts.sources)
+	c.checkTemplates(p, opts.generates)
+
+	for _, x := range []expr{opts.ifExpr, opts.unless} {
+		for _, o := range exprOperands(x) {
+			c.checkTemplates(p, o)
+		}
+	}
+}
+
+// report the vars which the templates in v look up but nothing sets
+func (c *checker) checkTemplates(p pos, v interface{}) {
+	switch val := v.(type) {
+	case string:
+		if !strings.Contains(val, "{{") {
+			return
+		}
+
+		tmpl, err := mustache.ParseString(val)
+
+		if err != nil {
+			c.report(p, "invalid template: %s", err)
+			return
+		}
+
+		for _, name := range tmpl.Names() {
+			name = strings.TrimLeft(strings.TrimSpace(name), "&")
+
+			if name == "." || strings.HasSuffix(name, "?") || c.vars[firstName(name)] {
+				continue
+			}
+
+			if c.cliVars {
+				c.hint(p, "template var \"%s\" is never set, unless given on the command line; declare it in params: to document it", name)
+			} else {
+				c.report(p, "template var \"%s\" is never set", name)
+			}
+		}
+	case []string:
+		for _, s := range val {
+			c.checkTemplates(p, s)
+		}
+	case []interface{}:
+		for _, v2 := range val {
+			c.checkTemplates(p, v2)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+
+		for k := range val {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			c.checkTemplates(p, k)
+			c.checkTemplates(p, val[k])
+		}
+	}
+}
+
+func firstName(name string) string {
+	return strings.SplitN(name, ".", 2)[0]
+}
+
+// the word closest to s, if it is close enough to be a typo of it
+func closest(s string, words []string) string {
+	best := ""
+	min := 3
+
+	for _, w := range words {
+		if d := editDistance(strings.ToLower(s), w); d < min && d < len(w) {
+			best, min = w, d
+		}
+	}
+
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			cur[j] = cur[j-1] + 1
+
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+
+			if prev[j-1]+cost < cur[j] {
+				cur[j] = prev[j-1] + cost
+			}
+		}
+
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
@@ -0,0 +1,155 @@
+package src
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	d, err := newTmpdir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(d.dir)
+
+	ioutil.WriteFile(d.dir+"/lib.yml", []byte(`
+- task:
+    name: Lint
+    shell: echo lint
+
+- task:
+    name: helper
+    shell: echo helper
+
+- task:
+    name: unused
+    shell: echo unused
+
+- include: { main: ./Taskies }
+`), 0644)
+
+	ioutil.WriteFile(d.dir+"/Taskies", []byte(`
+- include: { lib: ./lib.yml }
+
+- set:
+    target: linux
+
+- task:
+    name: Build
+    descripton: builds it
+    deps: [lib.Lint, missing]
+    run:
+      - shell: go build -o {{out}} {{target}}
+      - compile
+      - lib.helper
+      - Later
+
+- task:
+    name: Later
+    shell: echo {{#TASKS}}{{Build.OUT}}{{/TASKS}}
+
+- task:
+    name: later
+    shell: echo {{target}}
+
+- tsk:
+    name: Typo
+
+- shell: echo {{missing}}
+`), 0644)
+
+	problems, hints := Check(d.dir + "/Taskies")
+	lines := make([]string, len(problems))
+
+	for i, p := range problems {
+		lines[i] = strings.TrimPrefix(p.Error(), d.dir+"/")
+	}
+
+	expected := []string{
+		`Taskies:9:5: key "descripton" of task "Build" is neither a setting nor a known task, did you mean "description"?`,
+		`Taskies:10:5: task "Build" depends on unknown task "missing"`,
+		`Taskies:13:9: task "Build" references unknown task "compile"`,
+		`Taskies:14:9: task "Build" references private task "lib.helper", only tasks starting with an uppercase letter can be used by other files`,
+		`Taskies:15:9: task "Build" references task "Later" before it is defined`,
+		`Taskies:21:3: task "later" only differs by case from task "Later" at line 17`,
+		`Taskies:21:3: private task "later" is never used`,
+		`Taskies:25:3: "tsk" is neither an instruction nor a known task, did you mean "task"?`,
+		`Taskies:28:3: template var "missing" is never set`,
+		`lib.yml:10:3: private task "unused" is never used`,
+		`lib.yml:14:3: Include cycle detected: ` + d.dir + `/Taskies -> ` + d.dir + `/lib.yml -> ` + d.dir + `/Taskies`,
+	}
+
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(lines, "\n"))
+	}
+
+	expected = []string{
+		`Taskies:12:9: template var "out" is never set, unless given on the command line; declare it in params: to document it`,
+	}
+
+	if len(hints) != 1 || strings.TrimPrefix(hints[0].Error(), d.dir+"/") != expected[0] {
+		t.Fatalf("Expected hints %v, got %v", expected, hints)
+	}
+
+	// vars given on the command line, as in taskies Hello -name bob
+	n, _ := d.addFile([]byte(`
+- task:
+    name: Hello
+    shell: echo hello {{name}}
+
+- task:
+    name: Bye
+    params: [who]
+    shell: echo bye {{who}}
+`))
+
+	if problems, hints := Check(n); len(problems) != 0 || len(hints) != 1 {
+		t.Fatalf("Expected no problems and a hint, got %v and %v", problems, hints)
+	}
+
+	// but not to tasks with params, nor to private tasks
+	n, err = d.addFile([]byte(`
+- task:
+    name: Bye
+    params: [who]
+    shell: echo bye {{who}} {{whom}}
+
+- task:
+    name: greet
+    shell: echo hi {{name}}
+
+- task:
+    name: Welcome
+    run: greet
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	problems, hints = Check(n)
+	lines = make([]string, len(problems))
+
+	for i, p := range problems {
+		lines[i] = strings.TrimPrefix(p.Error(), d.dir+"/")
+	}
+
+	expected = []string{
+		`:5:5: template var "whom" is never set`,
+		`:9:5: template var "name" is never set`,
+	}
+
+	if len(hints) != 0 || len(lines) != len(expected) {
+		t.Fatalf("Expected problems %v and no hints, got %v and %v", expected, lines, hints)
+	}
+
+	for i, l := range lines {
+		if !strings.HasSuffix(l, expected[i]) {
+			t.Fatalf("Expected problems %v, got %v", expected, lines)
+		}
+	}
+}
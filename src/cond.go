@@ -0,0 +1,303 @@
+package src
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// a parsed if/unless expression. Operands are rendered with template()
+// when the expression is evaluated, so a rendered value containing
+// spaces or quotes is still compared as a whole. Supports ==, !=, <, <=,
+// >, >=, &&, ||, ! and parentheses. A value on its own is false when it
+// is empty, "false", "0", "no" or "off"
+type expr interface {
+	eval(*Env) string
+}
+
+type operand string
+
+func (o operand) eval(e *Env) string {
+	return strings.TrimSpace(template(string(o), e).(string))
+}
+
+// the operands of x, in the order they appear
+func exprOperands(x expr) []string {
+	switch x := x.(type) {
+	case operand:
+		return []string{string(x)}
+	case *notExpr:
+		return exprOperands(x.x)
+	case *binaryExpr:
+		return append(exprOperands(x.l), exprOperands(x.r)...)
+	}
+
+	return nil
+}
+
+type notExpr struct {
+	x expr
+}
+
+func (n *notExpr) eval(e *Env) string {
+	return boolStr(!truthy(n.x.eval(e)))
+}
+
+type binaryExpr struct {
+	op   string
+	l, r expr
+}
+
+func (b *binaryExpr) eval(e *Env) string {
+	switch b.op {
+	case "&&":
+		return boolStr(truthy(b.l.eval(e)) && truthy(b.r.eval(e)))
+	case "||":
+		return boolStr(truthy(b.l.eval(e)) || truthy(b.r.eval(e)))
+	}
+
+	l, r := b.l.eval(e), b.r.eval(e)
+	c := strings.Compare(l, r)
+
+	lf, err1 := strconv.ParseFloat(l, 64)
+	rf, err2 := strconv.ParseFloat(r, 64)
+
+	if err1 == nil && err2 == nil {
+		switch {
+		case lf < rf:
+			c = -1
+		case lf > rf:
+			c = 1
+		default:
+			c = 0
+		}
+	}
+
+	switch b.op {
+	case "==":
+		return boolStr(c == 0)
+	case "!=":
+		return boolStr(c != 0)
+	case "<":
+		return boolStr(c < 0)
+	case "<=":
+		return boolStr(c <= 0)
+	case ">":
+		return boolStr(c > 0)
+	default:
+		return boolStr(c >= 0)
+	}
+}
+
+func truthy(s string) bool {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "false", "0", "no", "off":
+		return false
+	}
+
+	return true
+}
+
+func boolStr(b bool) string {
+	if b {
+		return "true"
+	}
+
+	return "false"
+}
+
+func parseExpr(s string) (expr, error) {
+	toks, err := tokenizeExpr(s)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p := &exprParser{toks: toks}
+	x, err := p.or()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if p.i < len(p.toks) {
+		return nil, fmt.Errorf("Unexpected \"%s\" in condition \"%s\"", p.toks[p.i].s, s)
+	}
+
+	return x, nil
+}
+
+type exprToken struct {
+	s  string
+	op bool
+}
+
+var exprOps = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")"}
+
+func tokenizeExpr(s string) ([]exprToken, error) {
+	toks := make([]exprToken, 0)
+	i := 0
+
+Outer:
+	for i < len(s) {
+		c := s[i]
+
+		if c == ' ' || c == '\t' || c == '\n' {
+			i++
+			continue
+		}
+
+		for _, op := range exprOps {
+			if strings.HasPrefix(s[i:], op) {
+				toks = append(toks, exprToken{op, true})
+				i += len(op)
+				continue Outer
+			}
+		}
+
+		if c == '"' || c == '\'' {
+			end := strings.IndexByte(s[i+1:], c)
+
+			if end < 0 {
+				return nil, fmt.Errorf("Unterminated string in condition \"%s\"", s)
+			}
+
+			toks = append(toks, exprToken{s[i+1 : i+1+end], false})
+			i += end + 2
+			continue
+		}
+
+		start := i
+
+		for i < len(s) && !strings.ContainsRune(" \t\n=!<>&|()\"'", rune(s[i])) {
+			// keep template tags, and any spaces inside them, in one operand
+			if strings.HasPrefix(s[i:], "{{") {
+				end := strings.Index(s[i:], "}}")
+
+				if end < 0 {
+					return nil, fmt.Errorf("Unterminated tag in condition \"%s\"", s)
+				}
+
+				i += end + 2
+				continue
+			}
+
+			i++
+		}
+
+		toks = append(toks, exprToken{s[start:i], false})
+	}
+
+	return toks, nil
+}
+
+type exprParser struct {
+	toks []exprToken
+	i    int
+}
+
+func (p *exprParser) peek(ops ...string) string {
+	if p.i >= len(p.toks) || !p.toks[p.i].op {
+		return ""
+	}
+
+	for _, op := range ops {
+		if p.toks[p.i].s == op {
+			return op
+		}
+	}
+
+	return ""
+}
+
+func (p *exprParser) or() (expr, error) {
+	return p.binary(p.and, "||")
+}
+
+func (p *exprParser) and() (expr, error) {
+	return p.binary(p.not, "&&")
+}
+
+func (p *exprParser) binary(next func() (expr, error), ops ...string) (expr, error) {
+	l, err := next()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for op := p.peek(ops...); op != ""; op = p.peek(ops...) {
+		p.i++
+		r, err := next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		l = &binaryExpr{op, l, r}
+	}
+
+	return l, nil
+}
+
+func (p *exprParser) not() (expr, error) {
+	if p.peek("!") != "" {
+		p.i++
+		x, err := p.not()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &notExpr{x}, nil
+	}
+
+	l, err := p.value()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if op := p.peek("==", "!=", "<=", ">=", "<", ">"); op != "" {
+		p.i++
+		r, err := p.value()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &binaryExpr{op, l, r}, nil
+	}
+
+	return l, nil
+}
+
+func (p *exprParser) value() (expr, error) {
+	if p.i >= len(p.toks) {
+		return nil, fmt.Errorf("Unexpected end of condition")
+	}
+
+	tok := p.toks[p.i]
+	p.i++
+
+	if !tok.op {
+		return operand(tok.s), nil
+	}
+
+	if tok.s != "(" {
+		return nil, fmt.Errorf("Unexpected \"%s\" in condition", tok.s)
+	}
+
+	x, err := p.or()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek(")") == "" {
+		return nil, fmt.Errorf("Missing \")\" in condition")
+	}
+
+	p.i++
+
+	return x, nil
+}
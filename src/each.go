@@ -0,0 +1,150 @@
+package src
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// a value in the list an each: run item iterates over
+type eachItem struct {
+	key   interface{}
+	value interface{}
+}
+
+var singleTag = regexp.MustCompile(`^\s*{{\s*([^{}#^/!>&=\s]+)\s*}}\s*$`)
+
+// resolve the value of an each: key into the items to iterate over. A
+// string holding a single tag like "{{targets}}" is looked up as is so
+// lists and maps keep their structure, any other string is rendered and
+// split into lines. An optional tag like "{{targets?}}" which isn't set
+// gives no items
+func eachItems(each interface{}, r RunContext) ([]eachItem, error) {
+	e := r.Env()
+
+	if str, ok := each.(string); ok {
+		if m := singleTag.FindStringSubmatch(str); m != nil {
+			name := strings.TrimSuffix(m[1], "?")
+			v := e.GetVar(name)
+
+			if v == nil && name != m[1] {
+				return nil, nil
+			}
+
+			if v == nil {
+				return nil, fmt.Errorf("Missing var \"%s\" in each", name)
+			}
+
+			return itemsOf(v), nil
+		}
+	}
+
+	v, err := render(r, each)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return itemsOf(v), nil
+}
+
+func itemsOf(v interface{}) []eachItem {
+	items := make([]eachItem, 0)
+
+	if vs, ok := v.(*varSet); ok {
+		vs.l.RLock()
+		defer vs.l.RUnlock()
+
+		v = vs.vals
+	}
+
+	r := reflect.ValueOf(v)
+
+	switch r.Kind() {
+	case reflect.Slice:
+		l := r.Len()
+
+		for i := 0; i < l; i++ {
+			items = append(items, eachItem{i, r.Index(i).Interface()})
+		}
+	case reflect.Map:
+		keys := make([]string, 0)
+
+		for _, k := range r.MapKeys() {
+			keys = append(keys, k.String())
+		}
+
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			items = append(items, eachItem{k, r.MapIndex(reflect.ValueOf(k)).Interface()})
+		}
+	case reflect.String:
+		for _, line := range strings.Split(r.String(), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				items = append(items, eachItem{len(items), line})
+			}
+		}
+	default:
+		items = append(items, eachItem{0, v})
+	}
+
+	return items
+}
+
+// run the body of an each: run item once per item, each time in a child
+// env with ITEM, KEY and INDEX set. The result of every run is kept in
+// order in RESULTS
+func (t *optionsTask) runEach(r RunContext) error {
+	items, err := eachItems(t.opts.each, r)
+
+	if err != nil {
+		return err
+	}
+
+	results := make([]interface{}, 0, len(items))
+
+	defer func() {
+		r.Env().SetVar("RESULTS", results)
+	}()
+
+	for i, item := range items {
+		env := r.Env().Child()
+
+		env.SetVar("ITEM", item.value)
+		env.SetVar("KEY", item.key)
+		env.SetVar("INDEX", i)
+
+		err := r.Clone(nil, nil, nil, env).Run(t.Task)
+
+		if last := env.vars.Get("LAST"); last != nil {
+			results = append(results, last)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// the results of every run of an each: run item, false if t doesn't
+// iterate
+func eachResults(t Task, e *Env) ([]interface{}, bool) {
+	ot, ok := t.(*optionsTask)
+
+	if !ok || ot.opts.each == nil {
+		return nil, false
+	}
+
+	results, _ := e.vars.Get("RESULTS").([]interface{})
+
+	if results == nil {
+		results = make([]interface{}, 0)
+	}
+
+	return results, true
+}
@@ -2,6 +2,9 @@ package src
 
 import (
 	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
 )
@@ -22,6 +25,32 @@ type Env struct {
 	tasks            []string
 	exportedTasks    []string
 	exportedTasksMap map[string]bool
+
+	// names reserved for results under TASKS which may not be set yet
+	resultLock  sync.Mutex
+	resultNames map[string]bool
+}
+
+// reserve the name the result of a run of task name is kept under in
+// TASKS, adding _1, _2 and so on when name is taken. Tasks running in
+// parallel in e each get their own name
+func (e *Env) reserveResult(name string) string {
+	e.resultLock.Lock()
+	defer e.resultLock.Unlock()
+
+	if e.resultNames == nil {
+		e.resultNames = make(map[string]bool)
+	}
+
+	n := name
+
+	for i := 1; e.resultNames[n] || e.GetVar("TASKS."+n) != nil; i++ {
+		n = fmt.Sprintf("%s_%d", name, i)
+	}
+
+	e.resultNames[n] = true
+
+	return n
 }
 
 func (e *Env) Id() string {
@@ -41,7 +70,7 @@ func (e *Env) Id() string {
 }
 
 func (e *Env) GetVar(k string) interface{} {
-	v := e.vars.get(k)
+	v := e.vars.Get(k)
 
 	if v != nil || e.IsRoot() {
 		return v
@@ -67,7 +96,68 @@ func (e *Env) SetVar(k string, v interface{}) {
 		v = template(v, e)
 	}
 
-	e.vars.set(k, v)
+	e.vars.Set(k, v)
+}
+
+// every var visible from e flattened into sorted TASKIES_* "KEY=value"
+// entries, nested keys joined with "_"
+func (e *Env) exportedVars() []string {
+	vars := make(map[string]string)
+	e.flatten(vars)
+
+	keys := make([]string, 0, len(vars))
+
+	for k := range vars {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	entries := make([]string, len(keys))
+
+	for i, k := range keys {
+		entries[i] = k + "=" + vars[k]
+	}
+
+	return entries
+}
+
+func (e *Env) flatten(vars map[string]string) {
+	// the first parent with a var wins, and e wins over its parents
+	for i := len(e.parents) - 1; i >= 0; i-- {
+		e.parents[i].flatten(vars)
+	}
+
+	flattenVar("TASKIES", e.vars, vars)
+}
+
+var envKeyChars = regexp.MustCompile(`[^A-Z0-9_]+`)
+
+func flattenVar(key string, v interface{}, vars map[string]string) {
+	if vs, ok := v.(*varSet); ok {
+		vs.l.RLock()
+		defer vs.l.RUnlock()
+
+		v = vs.vals
+	}
+
+	r := reflect.ValueOf(v)
+
+	switch r.Kind() {
+	case reflect.Map:
+		for _, k := range r.MapKeys() {
+			sub := envKeyChars.ReplaceAllString(strings.ToUpper(fmt.Sprintf("%v", k.Interface())), "_")
+			flattenVar(key+"_"+sub, r.MapIndex(k).Interface(), vars)
+		}
+	case reflect.Slice:
+		l := r.Len()
+
+		for i := 0; i < l; i++ {
+			flattenVar(fmt.Sprintf("%s_%d", key, i), r.Index(i).Interface(), vars)
+		}
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Float64:
+		vars[key] = fmt.Sprintf("%v", v)
+	}
 }
 
 func (e *Env) Tasks() []string {
@@ -125,9 +215,8 @@ func (e *Env) AddTask(t Task) {
 	defer e.taskLock.Unlock()
 
 	name := t.Name()
-	lname := strings.ToLower(name)
 
-	if name[0] != lname[0] {
+	if exported(name) {
 		e.exportedTasks = append(e.exportedTasks, name)
 		e.exportedTasksMap[name] = true
 	}
@@ -137,6 +226,14 @@ func (e *Env) AddTask(t Task) {
 	e.vars.Set(name, t)
 }
 
+// tasks whose name starts with an uppercase letter are listed, and can be
+// run from other files
+func exported(name string) bool {
+	lname := strings.ToLower(name)
+
+	return name != "" && name[0] != lname[0]
+}
+
 func (e *Env) Child() *Env {
 	e2 := NewEnv()
 	e2.addParent(e)
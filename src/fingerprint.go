@@ -0,0 +1,252 @@
+package src
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// directory in which the content hashes of task sources are kept, next
+// to the file defining the task
+const stateDir = ".taskies"
+
+// decides whether a task has to run by comparing the files matched
+// by its sources globs with the files matched by its generates globs
+// and the content hash stored by its last successful run. Runs with
+// other param values or generates are kept apart, so they aren't taken
+// to be up to date by each other
+type fingerprint struct {
+	id   string
+	task string
+	dir  string
+
+	// directory of the file defining the task, which sources and
+	// generates are relative to along with the dir of the task
+	base      string
+	sources   []string
+	generates []string
+	params    []string
+}
+
+// what the state of a run is stored under: the task along with the
+// values of its params and its rendered generates
+func (f *fingerprint) key(r RunContext) (string, error) {
+	key := f.id
+
+	for _, p := range f.params {
+		key += fmt.Sprintf("\x00%s=%v", p, r.Env().GetVar(p))
+	}
+
+	for _, g := range f.generates {
+		v, err := render(r, g)
+
+		if err != nil {
+			return "", err
+		}
+
+		key += "\x00" + v.(string)
+	}
+
+	return key, nil
+}
+
+func (f *fingerprint) upToDate(r RunContext) (bool, error) {
+	sources, err := expandGlobs(f.sources, joinDir(f.base, r.Dir()), r)
+
+	if err != nil {
+		return false, err
+	}
+
+	// nothing to compare, so the task always runs
+	if len(sources) == 0 {
+		fmt.Fprintf(r.Runtime().Err(), "Warning: sources of task \"%s\" match no files\n", f.task)
+		return false, nil
+	}
+
+	key, err := f.key(r)
+
+	if err != nil {
+		return false, err
+	}
+
+	// never run with these params
+	stored, err := ioutil.ReadFile(f.path(key))
+
+	if err != nil {
+		return false, nil
+	}
+
+	if len(f.generates) > 0 {
+		generated := true
+		oldest := time.Time{}
+
+		for _, g := range f.generates {
+			files, err := expandGlobs([]string{g}, joinDir(f.base, r.Dir()), r)
+
+			if err != nil {
+				return false, err
+			}
+
+			if len(files) == 0 {
+				generated = false
+				break
+			}
+
+			for _, file := range files {
+				m, err := modTime(file)
+
+				if err != nil {
+					return false, err
+				}
+
+				if oldest.IsZero() || m.Before(oldest) {
+					oldest = m
+				}
+			}
+		}
+
+		if !generated {
+			return false, nil
+		}
+
+		newest := time.Time{}
+
+		for _, file := range sources {
+			m, err := modTime(file)
+
+			if err != nil {
+				return false, err
+			}
+
+			if m.After(newest) {
+				newest = m
+			}
+		}
+
+		if oldest.After(newest) {
+			return true, nil
+		}
+	}
+
+	hash, err := hashFiles(sources)
+
+	if err != nil {
+		return false, err
+	}
+
+	return string(stored) == hash, nil
+}
+
+// record the hash of the sources after a successful run
+func (f *fingerprint) store(r RunContext) error {
+	sources, err := expandGlobs(f.sources, joinDir(f.base, r.Dir()), r)
+
+	if err != nil {
+		return err
+	}
+
+	hash, err := hashFiles(sources)
+
+	if err != nil {
+		return err
+	}
+
+	key, err := f.key(r)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(f.dir, 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(f.path(key), []byte(hash), 0644)
+}
+
+func (f *fingerprint) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(f.dir, hex.EncodeToString(sum[:]))
+}
+
+// expand the globs templated against r, relative to dir, into a sorted
+// list of files. Directories are walked recursively
+func expandGlobs(globs []string, dir string, r RunContext) ([]string, error) {
+	seen := make(map[string]bool)
+	files := make([]string, 0)
+
+	add := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() && !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+
+		return nil
+	}
+
+	for _, g := range globs {
+		rendered, err := render(r, g)
+
+		if err != nil {
+			return nil, err
+		}
+
+		matches, err := filepath.Glob(joinDir(dir, rendered.(string)))
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range matches {
+			if err := filepath.Walk(m, add); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sort.Strings(files)
+
+	return files, nil
+}
+
+func hashFiles(files []string) (string, error) {
+	h := sha256.New()
+
+	for _, file := range files {
+		f, err := os.Open(file)
+
+		if err != nil {
+			return "", err
+		}
+
+		io.WriteString(h, file+"\x00")
+		_, err = io.Copy(h, f)
+		f.Close()
+
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func modTime(file string) (time.Time, error) {
+	info, err := os.Stat(file)
+
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return info.ModTime(), nil
+}
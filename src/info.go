@@ -0,0 +1,144 @@
+package src
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Description of a task, as listed by taskies -l -format json|yaml
+type TaskInfo struct {
+	// Qualified name the task is run by, such as "other.Hello"
+	Name string `json:"name" yaml:"name"`
+
+	// Alias of the namespace the task was included from, "" for the root
+	Namespace   string      `json:"namespace" yaml:"namespace"`
+	Description string      `json:"description" yaml:"description"`
+	Params      []ParamInfo `json:"params" yaml:"params"`
+	Deps        []string    `json:"deps" yaml:"deps"`
+	File        string      `json:"file" yaml:"file"`
+	Line        int         `json:"line" yaml:"line"`
+	Structure   *StepInfo   `json:"structure" yaml:"structure"`
+}
+
+type ParamInfo struct {
+	Name        string      `json:"name" yaml:"name"`
+	Type        string      `json:"type" yaml:"type"`
+	Default     interface{} `json:"default,omitempty" yaml:"default,omitempty"`
+	Required    bool        `json:"required" yaml:"required"`
+	Description string      `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+// A step of a task. Type is "composite", "pipe" or "parallel" for steps
+// made of other steps, "task" for steps running another task, or the run
+// item type such as "shell" or "mkdir"
+type StepInfo struct {
+	Type    string      `json:"type" yaml:"type"`
+	Task    string      `json:"task,omitempty" yaml:"task,omitempty"`
+	Command string      `json:"command,omitempty" yaml:"command,omitempty"`
+	Args    interface{} `json:"args,omitempty" yaml:"args,omitempty"`
+	Var     string      `json:"var,omitempty" yaml:"var,omitempty"`
+	Each    interface{} `json:"each,omitempty" yaml:"each,omitempty"`
+	Line    int         `json:"line,omitempty" yaml:"line,omitempty"`
+	Steps   []*StepInfo `json:"steps,omitempty" yaml:"steps,omitempty"`
+}
+
+// Describe every task Tasks() lists
+func (r *Runtime) TaskInfos() []*TaskInfo {
+	names := r.Tasks()
+	infos := make([]*TaskInfo, 0, len(names))
+
+	for _, name := range names {
+		t := r.GetTask(name)
+
+		if t == nil {
+			continue
+		}
+
+		info := &TaskInfo{
+			Name:        name,
+			Description: t.Description(),
+			Params:      make([]ParamInfo, 0),
+			Deps:        make([]string, 0),
+			Structure:   describe(t),
+		}
+
+		if i := strings.LastIndex(name, "."); i >= 0 {
+			info.Namespace = name[:i]
+		}
+
+		for _, p := range taskParams(t) {
+			info.Params = append(info.Params, ParamInfo{p.name, p.typ, p.def, p.required, p.description})
+		}
+
+		if ot, ok := t.(*optionsTask); ok {
+			info.Deps = append(info.Deps, ot.opts.deps...)
+		}
+
+		if p := taskPos(t); p.known() {
+			info.File = p.file
+			info.Line = p.line
+		}
+
+		infos = append(infos, info)
+	}
+
+	return infos
+}
+
+func describe(t Task) *StepInfo {
+	if ot, ok := t.(*optionsTask); ok {
+		step := describe(ot.Task)
+
+		if ot.opts.each != nil {
+			step.Each = ot.opts.each
+		}
+
+		return step
+	}
+
+	step := &StepInfo{
+		Type: t.Type(),
+		Var:  t.Var(),
+		Line: taskPos(t).line,
+	}
+
+	var steps []Task
+
+	switch t2 := t.(type) {
+	case *compositeTask:
+		step.Type = "composite"
+		steps = t2.tasks
+	case *pipeTask:
+		step.Type = "pipe"
+		steps = t2.tasks
+	case *parallelTask:
+		step.Type = "parallel"
+		steps = t2.tasks
+	case *shellTask:
+		if t2.argv != nil {
+			step.Args = t2.argv
+		} else {
+			step.Command = t2.script
+		}
+	case *builtinTask:
+		step.Args = valueOf(t2.args)
+	case *funcTask:
+		step.Type = "task"
+		step.Task = t2.Type()
+		step.Args = valueOf(t2.args)
+	}
+
+	for _, t3 := range steps {
+		step.Steps = append(step.Steps, describe(t3))
+	}
+
+	return step
+}
+
+func valueOf(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+
+	return v.Interface()
+}
@@ -2,10 +2,52 @@ package src
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 	"reflect"
+	"strconv"
+	"strings"
 )
 
+var knownInstructions = map[string]bool{
+	"set":         true,
+	"task":        true,
+	"run":         true,
+	"pipe":        true,
+	"parallel":    true,
+	"include":     true,
+	"env":         true,
+	"interpreter": true,
+	"strict":      true,
+}
+
+// keys a task block accepts besides the run items it is made of, any
+// other key runs the task or builtin it names
+var taskKeys = map[string]bool{
+	"name":              true,
+	"description":       true,
+	"run":               true,
+	"pipe":              true,
+	"parallel":          true,
+	"continue_on_error": true,
+	"on_error":          true,
+	"finally":           true,
+	"max_concurrency":   true,
+	"set":               true,
+	"deps":              true,
+	"sources":           true,
+	"generates":         true,
+	"watch":             true,
+	"params":            true,
+	"timeout":           true,
+	"retry":             true,
+	"if":                true,
+	"unless":            true,
+	"dir":               true,
+	"env":               true,
+	"interpreter":       true,
+}
+
 func decodeInstruction(k string, v reflect.Value) (instruction, error) {
 	var ins instruction
 
@@ -19,8 +61,17 @@ func decodeInstruction(k string, v reflect.Value) (instruction, error) {
 	case "pipe":
 		ins = newRunTasks()
 		ins.(*runTasks).pipe = true
+	case "parallel":
+		ins = newRunTasks()
+		ins.(*runTasks).parallel = true
 	case "include":
 		ins = newIncludeNs()
+	case "env":
+		ins = &fileEnv{}
+	case "interpreter":
+		ins = &fileInterpreter{}
+	case "strict":
+		ins = &fileStrict{}
 	default:
 		ins = newRunTasks()
 		v = reflect.ValueOf(map[string]interface{}{k: v.Interface()})
@@ -30,6 +81,10 @@ func decodeInstruction(k string, v reflect.Value) (instruction, error) {
 		return nil, err
 	}
 
+	if rt, ok := ins.(*runTasks); ok && !knownInstructions[k] {
+		rt.tasks[0].implicit = true
+	}
+
 	return ins, nil
 }
 
@@ -39,23 +94,25 @@ type instruction interface {
 }
 
 func newIncludeNs() *includeNs {
-	return &includeNs{make([]*_ns, 0)}
+	return &includeNs{ns: make([]*_ns, 0)}
 }
 
 type includeNs struct {
-	ns []*_ns
+	ns  []*_ns
+	pos pos
 }
 
 type _ns struct {
 	alias string
 	path  string
+	chdir bool
 }
 
 func (t *includeNs) decode(data reflect.Value) error {
 	k := data.Kind()
 
 	if k == reflect.String {
-		t.ns = append(t.ns, &_ns{"", data.String()})
+		t.ns = append(t.ns, &_ns{"", data.String(), true})
 		return nil
 	}
 
@@ -74,12 +131,24 @@ func (t *includeNs) decode(data reflect.Value) error {
 		v := data.Index(i).Elem()
 
 		if v.Kind() == reflect.String {
-			t.ns = append(t.ns, &_ns{"", v.String()})
+			t.ns = append(t.ns, &_ns{"", v.String(), true})
 			continue
 		}
 
 		if v.Kind() != reflect.Map {
-			return fmt.Errorf("include item must be a string or map '{alias: path}'")
+			return errAt(i, fmt.Errorf("include item must be a string or map '{alias: path}'"))
+		}
+
+		// { path: ./other, as: alias, chdir: false }
+		if v.MapIndex(reflect.ValueOf("path")).IsValid() {
+			n, err := decodeIncludeItem(v)
+
+			if err != nil {
+				return errAt(i, err)
+			}
+
+			t.ns = append(t.ns, n)
+			continue
 		}
 
 		keys := v.MapKeys()
@@ -88,37 +157,78 @@ func (t *includeNs) decode(data reflect.Value) error {
 			vv := v.MapIndex(k).Elem()
 			ks := k.String()
 
-			t.ns = append(t.ns, &_ns{ks, vv.String()})
+			t.ns = append(t.ns, &_ns{ks, vv.String(), true})
 		}
 	}
 
 	return nil
 }
 
+func decodeIncludeItem(data reflect.Value) (*_ns, error) {
+	n := &_ns{chdir: true}
+
+	for _, k := range data.MapKeys() {
+		v := data.MapIndex(k).Elem()
+
+		switch k.String() {
+		case "path":
+			n.path = v.String()
+		case "as":
+			n.alias = v.String()
+		case "chdir":
+			b, err := strconv.ParseBool(v.String())
+
+			if err != nil {
+				return nil, errAt("chdir", fmt.Errorf("chdir of include must be a boolean"))
+			}
+
+			n.chdir = b
+		default:
+			return nil, errAt(k.String(), fmt.Errorf("Invalid include key \"%s\"", k.String()))
+		}
+	}
+
+	return n, nil
+}
+
+func (t *includeNs) locate(n *posNode) {
+	t.pos = n.position()
+}
+
 func (t *includeNs) exec(r *Runtime, ns Namespace, e *Env) error {
+	includes := make([]*_ns, 0, len(t.ns))
+
 	for _, ns1 := range t.ns {
-		p, err := filepath.Abs(ns1.path)
+		expanded, err := expandInclude(ns.Dir(), ns1)
 
 		if err != nil {
-			return err
+			return t.pos.wrap(err)
 		}
 
-		ns2, ast, loaded, err := r.nsg.load(p)
+		includes = append(includes, expanded...)
+	}
+
+	for _, ns1 := range includes {
+		ns2, ast, loaded, err := r.nsg.load(ns1.path, ns1.chdir)
 
 		if err != nil {
-			return err
+			return t.pos.wrap(err)
 		}
 
 		Debugf("[NS LOAD] [from=%s] [id=%s] [alias=%s] [loaded=%v]", ns.Id(), ns2.Id(), ns1.alias, loaded)
 
+		if err := r.nsg.enter(ns2.Id()); err != nil {
+			return t.pos.wrap(err)
+		}
+
 		if !loaded {
-			err := inDir(filepath.Dir(p), func() error {
-				return execAst(r, ns2, ns2.RootEnv(), ast)
-			})
+			err = execAst(r, ns2, ns2.RootEnv(), ast)
+		}
 
-			if err != nil {
-				return err
-			}
+		r.nsg.leave()
+
+		if err != nil {
+			return err
 		}
 
 		e.SetVar(ns1.alias, ns2.RootEnv())
@@ -127,10 +237,75 @@ func (t *includeNs) exec(r *Runtime, ns Namespace, e *Env) error {
 	return nil
 }
 
+// the files an include stands for, with absolute paths. Paths are
+// relative to the including file. A directory stands for the Taskies file
+// in it, and a glob for every file or directory it matches in lexical
+// order. Directories and glob matches are aliased by their base name
+// without extension, nested under the alias of the include if it has one
+func expandInclude(dir string, n *_ns) ([]*_ns, error) {
+	p, err := filepath.Abs(joinDir(dir, n.path))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.ContainsAny(n.path, "*?[") {
+		if info, err := os.Stat(p); err == nil && info.IsDir() {
+			return []*_ns{{includeAlias(n.alias, p, true), filepath.Join(p, "Taskies"), n.chdir}}, nil
+		}
+
+		return []*_ns{{n.alias, p, n.chdir}}, nil
+	}
+
+	matches, err := filepath.Glob(p)
+
+	if err != nil {
+		return nil, err
+	}
+
+	includes := make([]*_ns, 0, len(matches))
+
+	for _, m := range matches {
+		info, err := os.Stat(m)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			includes = append(includes, &_ns{includeAlias(n.alias, m, false), m, n.chdir})
+			continue
+		}
+
+		// directories without a Taskies file aren't components
+		if _, err := os.Stat(filepath.Join(m, "Taskies")); err == nil {
+			includes = append(includes, &_ns{includeAlias(n.alias, m, false), filepath.Join(m, "Taskies"), n.chdir})
+		}
+	}
+
+	return includes, nil
+}
+
+func includeAlias(alias, path string, keep bool) string {
+	if keep && alias != "" {
+		return alias
+	}
+
+	base := filepath.Base(path)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+
+	if alias == "" {
+		return base
+	}
+
+	return alias + "." + base
+}
+
 func newDefineTask() *defineTask {
 	return &defineTask{
 		runList: newRunTasks(),
 		set:     newSetVar(),
+		opts:    newTaskOptions(),
 	}
 }
 
@@ -139,6 +314,8 @@ type defineTask struct {
 	description string
 	runList     *runTasks
 	set         *setVar
+	opts        *taskOptions
+	pos         pos
 }
 
 func (t *defineTask) decode(data reflect.Value) error {
@@ -150,41 +327,163 @@ func (t *defineTask) decode(data reflect.Value) error {
 
 	for _, k := range keys {
 		ks := k.String()
-		v := data.MapIndex(k).Elem()
 
-		switch ks {
-		case "name":
-			t.name = v.String()
-		case "description":
-			t.description = v.String()
-		case "run":
-			t.runList.pipe = false
-			if err := t.runList.decode(v); err != nil {
-				return err
-			}
-		case "pipe":
-			t.runList.pipe = true
-			if err := t.runList.decode(v); err != nil {
-				return err
-			}
-		case "set":
-			if err := t.set.decode(v); err != nil {
-				return err
-			}
-		default:
-			err := t.runList.decode(reflect.ValueOf(map[string]interface{}{
-				ks: v.Interface(),
-			}))
+		if err := t.decodeKey(ks, data.MapIndex(k).Elem()); err != nil {
+			return errAt(ks, err)
+		}
+	}
 
-			if err != nil {
-				return err
-			}
+	if len(t.opts.generates) > 0 && len(t.opts.sources) == 0 {
+		return errAt("generates", invalidGenerates)
+	}
+
+	return nil
+}
+
+func (t *defineTask) decodeKey(ks string, v reflect.Value) error {
+	switch ks {
+	case "name":
+		t.name = v.String()
+	case "description":
+		t.description = v.String()
+	case "run":
+		t.runList.pipe = false
+		if err := t.decodeRun(ks, v); err != nil {
+			return err
+		}
+	case "pipe":
+		t.runList.pipe = true
+		if err := t.decodeRun(ks, v); err != nil {
+			return err
+		}
+	case "parallel":
+		t.runList.parallel = true
+		if err := t.decodeRun(ks, v); err != nil {
+			return err
+		}
+	case "continue_on_error":
+		b, err := strconv.ParseBool(v.String())
+
+		if err != nil {
+			return fmt.Errorf("continue_on_error must be a boolean")
+		}
+
+		t.runList.continueOnError = b
+	case "on_error":
+		t.opts.onError = newRunTasks()
+
+		if err := t.opts.onError.decode(v); err != nil {
+			return err
+		}
+	case "finally":
+		t.opts.finally = newRunTasks()
+
+		if err := t.opts.finally.decode(v); err != nil {
+			return err
+		}
+	case "max_concurrency":
+		max, err := decodeInt(v)
+
+		if err != nil {
+			return invalidMaxConcurrency
+		}
+
+		t.runList.max = max
+	case "set":
+		if err := t.set.decode(v); err != nil {
+			return err
+		}
+	case "deps":
+		deps, err := decodeStrings(v)
+
+		if err != nil {
+			return fmt.Errorf("deps must be a task name or a list of task names")
+		}
+
+		t.opts.deps = deps
+	case "sources":
+		sources, err := decodeStrings(v)
+
+		if err != nil {
+			return fmt.Errorf("sources must be a glob or a list of globs")
 		}
+
+		t.opts.sources = sources
+	case "generates":
+		generates, err := decodeStrings(v)
+
+		if err != nil {
+			return fmt.Errorf("generates must be a glob or a list of globs")
+		}
+
+		t.opts.generates = generates
+	case "watch":
+		watch, err := decodeStrings(v)
+
+		if err != nil {
+			return fmt.Errorf("watch must be a glob or a list of globs")
+		}
+
+		t.opts.watch = watch
+	case "params":
+		params, err := decodeParams(v)
+
+		if err != nil {
+			return err
+		}
+
+		t.opts.params = params
+	default:
+		if taskKeys[ks] {
+			_, err := t.opts.decode(ks, v)
+			return err
+		}
+
+		err := t.decodeRun(ks, reflect.ValueOf(map[string]interface{}{
+			ks: v.Interface(),
+		}))
+
+		if err == nil {
+			t.runList.tasks[len(t.runList.tasks)-1].implicit = true
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// decode the run items under key, which are found under key in the
+// positions of the task too
+func (t *defineTask) decodeRun(key string, v reflect.Value) error {
+	n := len(t.runList.tasks)
+
+	if err := t.runList.decode(v); err != nil {
+		return err
+	}
+
+	for _, rt := range t.runList.tasks[n:] {
+		rt.path = append([]interface{}{key}, rt.path...)
 	}
 
 	return nil
 }
 
+func (t *defineTask) locate(n *posNode) {
+	t.pos = n.position()
+	t.opts.depsPos = n.key("deps").position()
+	t.set.locate(n.key("set"))
+	t.runList.locate(n)
+
+	if t.opts.onError != nil {
+		t.opts.onError.locate(n.key("on_error"))
+	}
+
+	if t.opts.finally != nil {
+		t.opts.finally.locate(n.key("finally"))
+	}
+}
+
 func (t *defineTask) exec(r *Runtime, ns Namespace, e *Env) error {
 	tsk, err := task(ns, e, t.name, t.description, t.set.vars, t.runList)
 
@@ -192,6 +491,10 @@ func (t *defineTask) exec(r *Runtime, ns Namespace, e *Env) error {
 		return err
 	}
 
+	if tsk, err = withOptions(ns, tsk, t.opts); err != nil {
+		return err
+	}
+
 	e.AddTask(tsk)
 	Debugf("[DEFINE TASK] [NAME=%s] [ENV=%s] %#v", tsk.Name(), e.Id(), tsk)
 
@@ -205,16 +508,44 @@ func newRunTasks() *runTasks {
 }
 
 type runTasks struct {
-	tasks []*runTask
-	pipe  bool
+	tasks           []*runTask
+	pipe            bool
+	parallel        bool
+	max             int
+	continueOnError bool
+	pos             pos
 }
 
 func (t *runTasks) decode(data reflect.Value) error {
+	var path []interface{}
+
+	// parallel: { max_concurrency: 2, run: [...] }
+	if t.parallel && data.Kind() == reflect.Map {
+		if mc := data.MapIndex(reflect.ValueOf("max_concurrency")); mc.IsValid() {
+			max, err := decodeInt(mc.Elem())
+
+			if err != nil {
+				return errAt("max_concurrency", invalidMaxConcurrency)
+			}
+
+			t.max = max
+			data = data.MapIndex(reflect.ValueOf("run"))
+
+			if !data.IsValid() {
+				return fmt.Errorf("parallel block is missing its run list")
+			}
+
+			data = data.Elem()
+			path = []interface{}{"run"}
+		}
+	}
+
 	if data.Kind() != reflect.Slice {
 		t2 := newRunTask()
+		t2.path = path
 
 		if err := t2.decode(data); err != nil {
-			return err
+			return t2.errAt(err)
 		}
 
 		t.tasks = append(t.tasks, t2)
@@ -226,9 +557,10 @@ func (t *runTasks) decode(data reflect.Value) error {
 	for i := 0; i < l; i++ {
 		v := data.Index(i).Elem()
 		t2 := newRunTask()
+		t2.path = append(append([]interface{}{}, path...), i)
 
 		if err := t2.decode(v); err != nil {
-			return err
+			return t2.errAt(err)
 		}
 
 		t.tasks = append(t.tasks, t2)
@@ -237,6 +569,18 @@ func (t *runTasks) decode(data reflect.Value) error {
 	return nil
 }
 
+// run items without a position of their own get the position of the
+// list they are in
+func (t *runTasks) locate(n *posNode) {
+	t.pos = n.position()
+
+	for _, rt := range t.tasks {
+		if rt.pos = n.at(rt.path).position(); !rt.pos.known() {
+			rt.pos = t.pos
+		}
+	}
+}
+
 func (t *runTasks) exec(r *Runtime, ns Namespace, e *Env) error {
 	tsk, err := task(ns, e, "anon", "", nil, t)
 
@@ -244,17 +588,41 @@ func (t *runTasks) exec(r *Runtime, ns Namespace, e *Env) error {
 		return err
 	}
 
-	return r.runWithDefaults(tsk)
+	if tsk, err = withOptions(ns, tsk, newTaskOptions()); err != nil {
+		return err
+	}
+
+	return r.runWithDefaults(ns.Dir(), e, tsk)
 }
 
 func newRunTask() *runTask {
-	return &runTask{}
+	return &runTask{
+		opts: newTaskOptions(),
+	}
 }
 
 type runTask struct {
 	task    string
 	varName string
 	args    reflect.Value
+	opts    *taskOptions
+
+	// where the item is in the node of the list it was decoded from
+	path []interface{}
+	pos  pos
+
+	// whether the item comes from an unknown key of a task or
+	// instruction, which are taken to be run items
+	implicit bool
+}
+
+// mark err as found in the item
+func (t *runTask) errAt(err error) error {
+	for i := len(t.path) - 1; i >= 0; i-- {
+		err = errAt(t.path[i], err)
+	}
+
+	return err
 }
 
 func (t *runTask) decode(data reflect.Value) error {
@@ -280,9 +648,27 @@ func (t *runTask) decode(data reflect.Value) error {
 			t.varName = v.String()
 		case "args":
 			t.args = v
+		case "ignore_error":
+			b, err := strconv.ParseBool(v.String())
+
+			if err != nil {
+				return errAt(ks, fmt.Errorf("ignore_error must be a boolean"))
+			}
+
+			t.opts.ignoreErr = b
+		case "each":
+			t.opts.each = v.Interface()
 		default:
+			if ok, err := t.opts.decode(ks, v); ok {
+				if err != nil {
+					return errAt(ks, err)
+				}
+
+				continue
+			}
+
 			if t.task != "" {
-				return invalidRunKey
+				return errAt(ks, invalidRunKey)
 			}
 
 			t.task = ks
@@ -301,6 +687,11 @@ func newSetVar() *setVar {
 
 type setVar struct {
 	vars map[string]interface{}
+	pos  pos
+}
+
+func (t *setVar) locate(n *posNode) {
+	t.pos = n.position()
 }
 
 func (t *setVar) decode(data reflect.Value) error {
@@ -325,49 +716,211 @@ func (t *setVar) exec(r *Runtime, ns Namespace, e *Env) error {
 	return nil
 }
 
+// env: at the top level of a file, added to the environment of the
+// commands run by the tasks defined after it
+type fileEnv struct {
+	vars map[string]interface{}
+	pos  pos
+}
+
+func (t *fileEnv) locate(n *posNode) {
+	t.pos = n.position()
+}
+
+func (t *fileEnv) decode(data reflect.Value) error {
+	vars, err := decodeEnv(data)
+	t.vars = vars
+
+	return err
+}
+
+func (t *fileEnv) exec(r *Runtime, ns Namespace, e *Env) error {
+	defaults := ns.defaults()
+
+	if defaults.env == nil {
+		defaults.env = make(map[string]interface{})
+	}
+
+	for k, v := range t.vars {
+		defaults.env[k] = v
+	}
+
+	return nil
+}
+
+// interpreter: at the top level of a file, used by the tasks defined
+// after it which don't set their own
+type fileInterpreter struct {
+	interp []string
+}
+
+func (t *fileInterpreter) decode(data reflect.Value) error {
+	interp, err := decodeInterpreter(data)
+	t.interp = interp
+
+	return err
+}
+
+func (t *fileInterpreter) exec(r *Runtime, ns Namespace, e *Env) error {
+	ns.defaults().interpreter = t.interp
+	return nil
+}
+
+// strict: at the top level of a file, makes the templates of the tasks
+// defined after it fail on vars which aren't set
+type fileStrict struct {
+	strict bool
+}
+
+func (t *fileStrict) decode(data reflect.Value) error {
+	b, err := strconv.ParseBool(data.String())
+
+	if err != nil {
+		return fmt.Errorf("strict must be a boolean")
+	}
+
+	t.strict = b
+
+	return nil
+}
+
+func (t *fileStrict) exec(r *Runtime, ns Namespace, e *Env) error {
+	ns.defaults().strict = t.strict
+	return nil
+}
+
+// decode a string or a list of strings
+func decodeStrings(data reflect.Value) ([]string, error) {
+	if data.Kind() == reflect.String {
+		return []string{data.String()}, nil
+	}
+
+	if data.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("Expected a string or a list of strings")
+	}
+
+	l := data.Len()
+	strs := make([]string, l)
+
+	for i := 0; i < l; i++ {
+		v := data.Index(i).Elem()
+
+		if v.Kind() != reflect.String {
+			return nil, fmt.Errorf("Expected a string or a list of strings")
+		}
+
+		strs[i] = v.String()
+	}
+
+	return strs, nil
+}
+
+func decodeInt(data reflect.Value) (int, error) {
+	if data.Kind() != reflect.String {
+		return 0, fmt.Errorf("Expected an integer")
+	}
+
+	return strconv.Atoi(data.String())
+}
+
 func task(ns Namespace, env *Env, name string, description string, export map[string]interface{}, tsks *runTasks) (Task, error) {
 	composite := len(tsks.tasks) != 1
 	tasks := make([]Task, 0)
+	owner := fmt.Sprintf("task \"%s\"", name)
+
+	if name == "anon" {
+		owner = "run"
+	}
 
 	for _, rt := range tsks.tasks {
 		name := name
 		desc := description
+		at := rt.pos
 
 		if composite {
 			name = ""
 			desc = ""
+		} else if tsks.pos.known() {
+			// the only item is the task itself
+			at = tsks.pos
 		}
 
+		var item Task
+
 		switch rt.task {
-		case "shell":
+		case "shell", "script", "exec":
 			exp := []map[string]interface{}{export}
 
 			if composite {
 				exp = make([]map[string]interface{}, 0)
 			}
 
-			task := &shellTask{
+			sh := &shellTask{
 				baseTask: &baseTask{
 					name:        name,
 					description: desc,
 					export:      exp,
 					varName:     rt.varName,
-					typ:         "shell",
+					typ:         rt.task,
 					env:         env,
+					pos:         at,
 				},
-				cmd: "sh",
-				args: []string{
-					"-c",
-					rt.args.String(),
-				},
 			}
 
-			tasks = append(tasks, task)
+			switch rt.task {
+			case "exec":
+				argv, err := decodeStrings(rt.args)
+
+				if err != nil || rt.args.Kind() != reflect.Slice || len(argv) == 0 {
+					return nil, rt.pos.wrap(invalidExecType)
+				}
+
+				sh.argv = argv
+			case "script":
+				sh.script = rt.args.String()
+				sh.file = true
+			default:
+				sh.script = rt.args.String()
+			}
+
+			item = sh
+		case "mkdir", "copy", "move", "remove", "write", "template":
+			exp := []map[string]interface{}{export}
+
+			if composite {
+				exp = make([]map[string]interface{}, 0)
+			}
+
+			item = &builtinTask{
+				baseTask: &baseTask{
+					name:        name,
+					description: desc,
+					export:      exp,
+					varName:     rt.varName,
+					typ:         rt.task,
+					env:         env,
+					pos:         at,
+				},
+				args: rt.args,
+				fn:   builtins[rt.task],
+			}
 		default:
 			task, _ := ns.RootEnv().GetTask(rt.task)
 
 			if task == nil {
-				return nil, fmt.Errorf("Missing task \"%s\"", rt.task)
+				return nil, rt.pos.errorf("%s references unknown task \"%s\"", owner, rt.task)
+			}
+
+			if rt.args.Kind() == reflect.Map {
+				names := make([]string, 0)
+
+				for _, k := range rt.args.MapKeys() {
+					names = append(names, k.String())
+				}
+
+				if err := checkParamNames(rt.task, taskParams(task), names); err != nil {
+					return nil, rt.pos.wrap(err)
+				}
 			}
 
 			exp := task.Export()
@@ -384,15 +937,40 @@ func task(ns Namespace, env *Env, name string, description string, export map[st
 			proxy.varName = rt.varName
 			proxy.export = exp
 			proxy.env = env
+			proxy.pos = at
 
-			tasks = append(tasks, proxy)
+			item = proxy
 		}
+
+		if !rt.opts.empty() {
+			var err error
+
+			if item, err = newOptionsTask(ns, item, rt.opts); err != nil {
+				return nil, err
+			}
+		}
+
+		tasks = append(tasks, item)
 	}
 
 	var task Task
 
 	if composite {
-		if tsks.pipe {
+		if tsks.parallel {
+			task = &parallelTask{
+				baseTask: &baseTask{
+					name:        name,
+					description: description,
+					typ:         name,
+					export:      []map[string]interface{}{export},
+					env:         env,
+					pos:         tsks.pos,
+				},
+				tasks:           tasks,
+				max:             tsks.max,
+				continueOnError: tsks.continueOnError,
+			}
+		} else if tsks.pipe {
 			task = &pipeTask{
 				baseTask: &baseTask{
 					name:        name,
@@ -400,6 +978,7 @@ func task(ns Namespace, env *Env, name string, description string, export map[st
 					typ:         name,
 					export:      []map[string]interface{}{export},
 					env:         env,
+					pos:         tsks.pos,
 				},
 				tasks: tasks,
 			}
@@ -411,8 +990,10 @@ func task(ns Namespace, env *Env, name string, description string, export map[st
 					typ:         name,
 					export:      []map[string]interface{}{export},
 					env:         env,
+					pos:         tsks.pos,
 				},
-				tasks: tasks,
+				tasks:           tasks,
+				continueOnError: tsks.continueOnError,
 			}
 		}
 	} else {
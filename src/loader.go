@@ -43,7 +43,7 @@ func (l *loader) load(path string) (*load, bool, error) {
 		return nil, false, err
 	}
 
-	ast, err := parseBytes(raw)
+	ast, err := parseFile(id, raw)
 
 	if err != nil {
 		return nil, false, err
@@ -57,3 +57,17 @@ func (l *loader) load(path string) (*load, bool, error) {
 
 	return l.loads[id], false, nil
 }
+
+// paths of every file loaded so far
+func (l *loader) paths() []string {
+	l.l.Lock()
+	defer l.l.Unlock()
+
+	paths := make([]string, 0, len(l.loads))
+
+	for id, _ := range l.loads {
+		paths = append(paths, id)
+	}
+
+	return paths
+}
@@ -1,32 +1,61 @@
 package src
 
 import (
+	"fmt"
+	"path/filepath"
+	"strings"
 	"sync"
 )
 
 type Namespace interface {
 	Id() string
+	// directory of the file the namespace was loaded from, "" if it
+	// wasn't loaded from a file
+	Dir() string
+	// directory the tasks defined in the namespace run from by default,
+	// "" to run them from the directory of whoever runs them
+	WorkDir() string
 	RootEnv() *Env
 	Tasks() []string
 	GetTask(string) Task
+
+	// options set at the top level of the file, such as env: and
+	// interpreter:, which apply to every task defined after them
+	defaults() *taskOptions
 }
 
 func newNs(id string) *ns {
 	return &ns{
-		id:  id,
-		env: NewEnv(),
+		id:   id,
+		env:  NewEnv(),
+		opts: newTaskOptions(),
 	}
 }
 
 type ns struct {
-	id  string
-	env *Env
+	id      string
+	dir     string
+	workDir string
+	env     *Env
+	opts    *taskOptions
 }
 
 func (n *ns) Id() string {
 	return n.id
 }
 
+func (n *ns) Dir() string {
+	return n.dir
+}
+
+func (n *ns) WorkDir() string {
+	return n.workDir
+}
+
+func (n *ns) defaults() *taskOptions {
+	return n.opts
+}
+
 func (n *ns) Tasks() []string {
 	return n.RootEnv().ExportedTasks()
 }
@@ -51,9 +80,57 @@ type nsGroup struct {
 	sync.Mutex
 	loader *loader
 	ns     map[string]Namespace
+	stack  includeStack
+}
+
+// mark the namespace with id as being executed. Fails when it already
+// is, as it then includes itself through the files on the stack
+func (n *nsGroup) enter(id string) error {
+	n.Lock()
+	defer n.Unlock()
+
+	return n.stack.enter(id)
 }
 
-func (n *nsGroup) load(path string) (Namespace, *ast, bool, error) {
+func (n *nsGroup) leave() {
+	n.Lock()
+	defer n.Unlock()
+
+	n.stack.leave()
+}
+
+// ids of the files being included, the file including them first
+type includeStack []string
+
+// push the file with id. Fails when it is already on the stack, as it
+// then includes itself through the files above it
+func (s *includeStack) enter(id string) error {
+	for i, id2 := range *s {
+		if id2 == id {
+			chain := make([]string, 0, len(*s)-i+1)
+
+			for _, id3 := range (*s)[i:] {
+				chain = append(chain, displayPath(id3))
+			}
+
+			chain = append(chain, displayPath(id))
+
+			return fmt.Errorf("Include cycle detected: %s", strings.Join(chain, " -> "))
+		}
+	}
+
+	*s = append(*s, id)
+
+	return nil
+}
+
+func (s *includeStack) leave() {
+	*s = (*s)[:len(*s)-1]
+}
+
+// load the namespace of the file at path. When chdir is set and the file
+// wasn't loaded before, its tasks run from the file's directory
+func (n *nsGroup) load(path string, chdir bool) (Namespace, *ast, bool, error) {
 	Debugf("[LOADING] %s", path)
 
 	n.Lock()
@@ -70,6 +147,12 @@ func (n *nsGroup) load(path string) (Namespace, *ast, bool, error) {
 	}
 
 	ns := newNs(l.id)
+	ns.dir = filepath.Dir(l.id)
+
+	if chdir {
+		ns.workDir = ns.dir
+	}
+
 	n.ns[l.id] = ns
 
 	return ns, l.ast, loaded, nil
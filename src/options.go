@@ -0,0 +1,587 @@
+package src
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func newTaskOptions() *taskOptions {
+	return &taskOptions{
+		deps:      make([]string, 0),
+		sources:   make([]string, 0),
+		generates: make([]string, 0),
+		watch:     make([]string, 0),
+	}
+}
+
+// settings declared next to the body of a task block, or next to a
+// run item, which control when and how the body is run
+type taskOptions struct {
+	deps        []string
+	sources     []string
+	generates   []string
+	watch       []string
+	params      []*param
+	timeout     time.Duration
+	retry       *retryPolicy
+	ignoreErr   bool
+	onError     *runTasks
+	finally     *runTasks
+	ifExpr      expr
+	unless      expr
+	each        interface{}
+	dir         string
+	env         map[string]interface{}
+	interpreter []string
+	strict      bool
+
+	// where deps are declared, for reporting missing ones
+	depsPos pos
+}
+
+func (o *taskOptions) empty() bool {
+	return len(o.deps) == 0 && len(o.sources) == 0 && len(o.watch) == 0 && o.params == nil &&
+		o.timeout == 0 && o.retry == nil && !o.ignoreErr && o.onError == nil && o.finally == nil &&
+		o.ifExpr == nil && o.unless == nil && o.each == nil && o.dir == "" && len(o.env) == 0 &&
+		o.interpreter == nil && !o.strict
+}
+
+// decode the options which both task blocks and run items accept.
+// Returns false if key isn't one of them
+func (o *taskOptions) decode(key string, v reflect.Value) (bool, error) {
+	switch key {
+	case "timeout":
+		d, err := decodeDuration(v)
+
+		if err != nil {
+			return true, invalidTimeout
+		}
+
+		o.timeout = d
+	case "retry":
+		p, err := decodeRetry(v)
+
+		if err != nil {
+			return true, err
+		}
+
+		o.retry = p
+	case "dir":
+		o.dir = v.String()
+	case "env":
+		env, err := decodeEnv(v)
+
+		if err != nil {
+			return true, err
+		}
+
+		o.env = env
+	case "interpreter":
+		interp, err := decodeInterpreter(v)
+
+		if err != nil {
+			return true, err
+		}
+
+		o.interpreter = interp
+	case "if", "unless":
+		x, err := parseExpr(v.String())
+
+		if err != nil {
+			return true, err
+		}
+
+		if key == "if" {
+			o.ifExpr = x
+		} else {
+			o.unless = x
+		}
+	default:
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// decode an env: map of environment variable -> templated value
+func decodeEnv(data reflect.Value) (map[string]interface{}, error) {
+	if data.Kind() != reflect.Map {
+		return nil, invalidEnvType
+	}
+
+	env := make(map[string]interface{})
+
+	for _, k := range data.MapKeys() {
+		env[k.String()] = data.MapIndex(k).Elem().Interface()
+	}
+
+	return env, nil
+}
+
+// decode an interpreter such as "bash -euo pipefail -c", or the same as
+// a list of args
+func decodeInterpreter(data reflect.Value) ([]string, error) {
+	args, err := decodeStrings(data)
+
+	if err == nil && data.Kind() == reflect.String {
+		args = strings.Fields(args[0])
+	}
+
+	if err != nil || len(args) == 0 {
+		return nil, invalidInterpreter
+	}
+
+	return args, nil
+}
+
+// render the values of an env: map into sorted "KEY=value" entries
+func environ(env map[string]interface{}, r RunContext) ([]string, error) {
+	keys := make([]string, 0, len(env))
+
+	for k := range env {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	entries := make([]string, len(keys))
+
+	for i, k := range keys {
+		v, err := render(r, env[k])
+
+		if err != nil {
+			return nil, err
+		}
+
+		entries[i] = fmt.Sprintf("%s=%v", k, v)
+	}
+
+	return entries, nil
+}
+
+// decode a duration like "1m30s", or a plain number of seconds
+func decodeDuration(data reflect.Value) (time.Duration, error) {
+	if data.Kind() != reflect.String {
+		return 0, fmt.Errorf("Expected a duration")
+	}
+
+	if secs, err := strconv.ParseFloat(data.String(), 64); err == nil {
+		return time.Duration(secs * float64(time.Second)), nil
+	}
+
+	return time.ParseDuration(data.String())
+}
+
+// wraps a defined task and applies its taskOptions every time it
+// runs, whether it is run directly or through a proxy
+type optionsTask struct {
+	Task
+	opts    *taskOptions
+	fp      *fingerprint
+	deps    []Task
+	checked bool
+	onError Task
+	finally Task
+}
+
+// wrap a task defined at the top level of ns with its options. Tasks of
+// namespaces with a WorkDir run from there unless their dir is absolute,
+// and get the file level env: and interpreter: of ns unless they
+// override them
+func withOptions(ns Namespace, t Task, opts *taskOptions) (Task, error) {
+	opts2 := *opts
+	opts = &opts2
+	defaults := ns.defaults()
+
+	if wd := ns.WorkDir(); wd != "" && !filepath.IsAbs(opts.dir) {
+		opts.dir = joinDir(wd, opts.dir)
+	}
+
+	if opts.interpreter == nil {
+		opts.interpreter = defaults.interpreter
+	}
+
+	opts.strict = opts.strict || defaults.strict
+
+	if len(defaults.env) > 0 {
+		env := make(map[string]interface{})
+
+		for k, v := range defaults.env {
+			env[k] = v
+		}
+
+		for k, v := range opts.env {
+			env[k] = v
+		}
+
+		opts.env = env
+	}
+
+	if opts.empty() {
+		return t, nil
+	}
+
+	ot, err := newOptionsTask(ns, t, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ot, nil
+}
+
+func newOptionsTask(ns Namespace, t Task, opts *taskOptions) (*optionsTask, error) {
+	// the other options apply to every iteration of an each: item
+	if opts.each != nil {
+		inner := *opts
+		inner.each = nil
+
+		if !inner.empty() {
+			body, err := newOptionsTask(ns, t, &inner)
+
+			if err != nil {
+				return nil, err
+			}
+
+			t = body
+		}
+
+		return &optionsTask{
+			Task: t,
+			opts: &taskOptions{each: opts.each, strict: opts.strict},
+		}, nil
+	}
+
+	ot := &optionsTask{
+		Task: t,
+		opts: opts,
+	}
+
+	var err error
+
+	if opts.onError != nil {
+		if ot.onError, err = task(ns, t.Env(), "on_error", "", nil, opts.onError); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.finally != nil {
+		if ot.finally, err = task(ns, t.Env(), "finally", "", nil, opts.finally); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(opts.sources) > 0 {
+		ot.fp = &fingerprint{
+			id:        ns.Id() + ":" + t.Name(),
+			task:      t.Name(),
+			dir:       filepath.Join(ns.Dir(), stateDir),
+			base:      ns.Dir(),
+			sources:   opts.sources,
+			generates: opts.generates,
+		}
+
+		for _, p := range opts.params {
+			ot.fp.params = append(ot.fp.params, p.name)
+		}
+	}
+
+	return ot, nil
+}
+
+// whether t is made strict by its own options, or those of its file
+func strictTask(t Task) bool {
+	ot, ok := t.(*optionsTask)
+
+	return ok && (ot.opts.strict || strictTask(ot.Task))
+}
+
+func (t *optionsTask) Run(r RunContext) error {
+	if t.opts.strict {
+		r = r.WithStrict(true)
+	}
+
+	if t.opts.each != nil {
+		return t.runEach(r)
+	}
+
+	// conditions may test params, so they need their defaults first
+	if err := applyParams(t.Name(), t.opts.params, r.Env()); err != nil {
+		return err
+	}
+
+	skip, err := t.skip(r)
+
+	if err != nil {
+		return err
+	}
+
+	if skip {
+		Debugf("[SKIP] %s", taskName(t))
+		r.Env().SetVar("SKIPPED", true)
+
+		if rt := r.Runtime(); rt.DryRun {
+			rt.printDry(r.Depth(), "# skipped")
+		}
+
+		return nil
+	}
+
+	err = t.run(r)
+
+	if err != nil {
+		// let on_error and finally see what went wrong
+		r.Env().SetVar("ERROR", err.Error())
+
+		if t.onError != nil {
+			if err2 := r.Run(t.onError); err2 != nil {
+				err = multiError{err, err2}
+			}
+		}
+	}
+
+	if t.finally != nil {
+		if err2 := r.Run(t.finally); err2 != nil {
+			if err == nil {
+				err = err2
+			} else {
+				err = multiError{err, err2}
+			}
+		}
+	}
+
+	if err != nil && t.opts.ignoreErr && r.Context().Err() == nil {
+		Debugf("[IGNORE ERROR] [TASK=%s] %s", taskName(t), err)
+		setResult(r.Env(), err)
+
+		return nil
+	}
+
+	return err
+}
+
+// whether the if/unless conditions rule out running the task. In strict
+// mode their operands can't use vars which aren't set
+func (t *optionsTask) skip(r RunContext) (bool, error) {
+	e := r.Env()
+
+	if r.Strict() {
+		for _, x := range []expr{t.opts.ifExpr, t.opts.unless} {
+			for _, o := range exprOperands(x) {
+				if _, err := render(r, o); err != nil {
+					return false, err
+				}
+			}
+		}
+	}
+
+	if t.opts.ifExpr != nil && !truthy(t.opts.ifExpr.eval(e)) {
+		return true, nil
+	}
+
+	return t.opts.unless != nil && truthy(t.opts.unless.eval(e)), nil
+}
+
+func (t *optionsTask) run(r RunContext) error {
+	if err := resolveDeps(t); err != nil {
+		return err
+	}
+
+	for _, dep := range t.deps {
+		if err := r.RunOnce(dep); err != nil {
+			return err
+		}
+	}
+
+	// deps run with their own dir, env and interpreter, everything else
+	// with these
+	if t.opts.dir != "" {
+		dir, err := render(r, t.opts.dir)
+
+		if err != nil {
+			return err
+		}
+
+		r = r.WithDir(dir.(string))
+	}
+
+	if len(t.opts.env) > 0 {
+		entries, err := environ(t.opts.env, r)
+
+		if err != nil {
+			return err
+		}
+
+		r = r.WithEnviron(entries)
+	}
+
+	if t.opts.interpreter != nil {
+		r = r.WithInterpreter(t.opts.interpreter)
+	}
+
+	if t.fp != nil {
+		ok, err := t.fp.upToDate(r)
+
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			Debugf("[UP TO DATE] %s", t.Name())
+			r.Env().SetVar("SKIPPED", true)
+
+			if rt := r.Runtime(); rt.DryRun {
+				rt.printDry(r.Depth(), "# up to date")
+			}
+
+			return nil
+		}
+	}
+
+	if err := t.runBody(r); err != nil {
+		return err
+	}
+
+	if t.fp != nil && !r.Runtime().DryRun {
+		return t.fp.store(r)
+	}
+
+	return nil
+}
+
+func (t *optionsTask) runBody(r RunContext) error {
+	for attempt := 1; ; attempt++ {
+		if t.opts.retry != nil {
+			r.Env().SetVar("ATTEMPT", attempt)
+		}
+
+		err := t.runAttempt(r)
+
+		if err == nil || t.opts.retry == nil || !t.opts.retry.retries(attempt, err) {
+			return err
+		}
+
+		rt := r.Runtime()
+
+		if w, ok := rt.Watcher.(RetryWatcher); ok {
+			if ch := w.Retry(rt, r.Env(), t, attempt, err); ch != nil {
+				<-ch
+			}
+		}
+
+		Debugf("[RETRY] [TASK=%s] [ATTEMPT=%d] %s", taskName(t), attempt, err)
+
+		if !t.opts.retry.wait(r.Context(), attempt) {
+			return err
+		}
+	}
+}
+
+func (t *optionsTask) runAttempt(r RunContext) error {
+	if t.opts.timeout <= 0 {
+		return t.Task.Run(r)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), t.opts.timeout)
+	defer cancel()
+
+	err := t.Task.Run(r.WithContext(ctx))
+
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("Task \"%s\" timed out after %s", taskName(t), t.opts.timeout)
+	}
+
+	return err
+}
+
+// name of a task, falling back to its type for anonymous run items
+func taskName(t Task) string {
+	if t.Name() != "" {
+		return t.Name()
+	}
+
+	return t.Type()
+}
+
+var depLock sync.Mutex
+
+// look up the dependencies of every task defined in e. This runs once a
+// file has been fully loaded so deps can reference tasks defined further
+// down
+func resolveAllDeps(e *Env) error {
+	for _, name := range e.Tasks() {
+		t, _ := e.GetTask(name)
+
+		if ot, ok := t.(*optionsTask); ok {
+			if err := resolveDeps(ot); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func resolveDeps(t *optionsTask) error {
+	depLock.Lock()
+	defer depLock.Unlock()
+
+	return t.resolve(nil)
+}
+
+func (t *optionsTask) resolve(stack []*optionsTask) error {
+	for i, t2 := range stack {
+		if t2 == t {
+			names := make([]string, 0, len(stack)-i+1)
+
+			for _, t3 := range stack[i:] {
+				names = append(names, t3.Name())
+			}
+
+			names = append(names, t.Name())
+
+			return t.opts.depsPos.errorf("Dependency cycle detected: %s", strings.Join(names, " -> "))
+		}
+	}
+
+	if t.checked {
+		return nil
+	}
+
+	if t.deps == nil {
+		deps := make([]Task, len(t.opts.deps))
+
+		for i, name := range t.opts.deps {
+			dep, _ := t.Env().GetTask(name)
+
+			if dep == nil {
+				return t.opts.depsPos.errorf("task \"%s\" depends on unknown task \"%s\"", t.Name(), name)
+			}
+
+			deps[i] = dep
+		}
+
+		t.deps = deps
+	}
+
+	stack = append(stack, t)
+
+	for _, dep := range t.deps {
+		if ot, ok := dep.(*optionsTask); ok {
+			if err := ot.resolve(stack); err != nil {
+				return err
+			}
+		}
+	}
+
+	t.checked = true
+
+	return nil
+}
@@ -0,0 +1,257 @@
+package src
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var paramTypes = map[string]bool{
+	"string": true,
+	"int":    true,
+	"bool":   true,
+	"list":   true,
+}
+
+// a typed parameter declared in the params block of a task
+type param struct {
+	name        string
+	typ         string
+	def         interface{}
+	required    bool
+	description string
+}
+
+// decode a params block, either a list of param maps (or plain names
+// for optional string params) or a map of name -> param map
+func decodeParams(data reflect.Value) ([]*param, error) {
+	params := make([]*param, 0)
+
+	switch data.Kind() {
+	case reflect.Slice:
+		l := data.Len()
+
+		for i := 0; i < l; i++ {
+			v := data.Index(i).Elem()
+
+			if v.Kind() == reflect.String {
+				params = append(params, &param{name: v.String(), typ: "string"})
+				continue
+			}
+
+			p, err := decodeParam("", v)
+
+			if err != nil {
+				return nil, err
+			}
+
+			params = append(params, p)
+		}
+	case reflect.Map:
+		keys := make([]string, 0)
+
+		for _, k := range data.MapKeys() {
+			keys = append(keys, k.String())
+		}
+
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			p, err := decodeParam(k, data.MapIndex(reflect.ValueOf(k)).Elem())
+
+			if err != nil {
+				return nil, err
+			}
+
+			params = append(params, p)
+		}
+	default:
+		return nil, invalidParamsType
+	}
+
+	return params, nil
+}
+
+func decodeParam(name string, data reflect.Value) (*param, error) {
+	if data.Kind() != reflect.Map {
+		return nil, invalidParamsType
+	}
+
+	p := &param{
+		name: name,
+		typ:  "string",
+	}
+
+	var def reflect.Value
+
+	for _, k := range data.MapKeys() {
+		v := data.MapIndex(k).Elem()
+
+		switch k.String() {
+		case "name":
+			p.name = v.String()
+		case "type":
+			p.typ = v.String()
+		case "default":
+			def = v
+		case "required":
+			b, err := strconv.ParseBool(v.String())
+
+			if err != nil {
+				return nil, fmt.Errorf("required of param \"%s\" must be a boolean", p.name)
+			}
+
+			p.required = b
+		case "description":
+			p.description = v.String()
+		default:
+			return nil, fmt.Errorf("Invalid param key \"%s\"", k.String())
+		}
+	}
+
+	if p.name == "" {
+		return nil, fmt.Errorf("param is missing its name")
+	}
+
+	if !paramTypes[p.typ] {
+		return nil, fmt.Errorf("Invalid type \"%s\" for param \"%s\", expected string, int, bool or list", p.typ, p.name)
+	}
+
+	if def.IsValid() {
+		v, err := p.coerce(def.Interface())
+
+		if err != nil {
+			return nil, err
+		}
+
+		p.def = v
+	}
+
+	return p, nil
+}
+
+// convert v to the param's type
+func (p *param) coerce(v interface{}) (interface{}, error) {
+	invalid := fmt.Errorf("Invalid value %#v for param \"%s\", expected %s", v, p.name, p.typ)
+
+	if sl, ok := v.([]interface{}); ok {
+		if p.typ != "list" {
+			return nil, invalid
+		}
+
+		return sl, nil
+	}
+
+	switch p.typ {
+	case "int":
+		switch val := v.(type) {
+		case int:
+			return val, nil
+		case string:
+			i, err := strconv.Atoi(strings.TrimSpace(val))
+
+			if err != nil {
+				return nil, invalid
+			}
+
+			return i, nil
+		}
+	case "bool":
+		switch val := v.(type) {
+		case bool:
+			return val, nil
+		case string:
+			b, err := strconv.ParseBool(strings.TrimSpace(val))
+
+			if err != nil {
+				return nil, invalid
+			}
+
+			return b, nil
+		}
+	case "list":
+		if str, ok := v.(string); ok {
+			sl := make([]interface{}, 0)
+
+			for _, item := range strings.Split(str, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					sl = append(sl, item)
+				}
+			}
+
+			return sl, nil
+		}
+	default:
+		if r := reflect.ValueOf(v); r.Kind() != reflect.Map && !isVarSet(v) {
+			return fmt.Sprintf("%v", v), nil
+		}
+	}
+
+	return nil, invalid
+}
+
+// check the params declared by task against the vars in e, setting
+// defaults and coercing values to their declared type. Coerced values are
+// stored as they are, rather than through SetVar which would template them
+// again, so int, bool and list params keep their type
+func applyParams(task string, params []*param, e *Env) error {
+	for _, p := range params {
+		v := e.GetVar(p.name)
+
+		if v == nil {
+			if p.def != nil {
+				e.SetVar(p.name, p.def)
+				continue
+			}
+
+			if p.required {
+				return fmt.Errorf("Missing required param \"%s\" for task \"%s\"", p.name, task)
+			}
+
+			continue
+		}
+
+		v2, err := p.coerce(v)
+
+		if err != nil {
+			return fmt.Errorf("%s of task \"%s\"", err, task)
+		}
+
+		e.vars.Set(p.name, v2)
+	}
+
+	return nil
+}
+
+// the params declared by a task, nil if it doesn't declare any
+func taskParams(t Task) []*param {
+	if ot, ok := t.(*optionsTask); ok {
+		return ot.opts.params
+	}
+
+	return nil
+}
+
+func checkParamNames(task string, params []*param, names []string) error {
+	if params == nil {
+		return nil
+	}
+
+	known := make(map[string]bool)
+	valid := make([]string, len(params))
+
+	for i, p := range params {
+		known[p.name] = true
+		valid[i] = p.name
+	}
+
+	for _, name := range names {
+		if !known[name] {
+			return fmt.Errorf("Unknown param \"%s\" for task \"%s\", expected one of: %s", name, task, strings.Join(valid, ", "))
+		}
+	}
+
+	return nil
+}
@@ -1,9 +1,12 @@
 package src
 
 import (
+	"errors"
 	"fmt"
 	"launchpad.net/goyaml"
 	"reflect"
+	"regexp"
+	"strconv"
 )
 
 var (
@@ -16,27 +19,60 @@ var (
 	invalidRunType         = fmt.Errorf("Run must be a map")
 	invalidRunKey          = fmt.Errorf("Invalid run key found")
 	invalidSetType         = fmt.Errorf("Set must be a map")
+	invalidMaxConcurrency  = fmt.Errorf("max_concurrency must be an integer")
+	invalidParamsType      = fmt.Errorf("params must be a list or a map of params")
+	invalidTimeout         = fmt.Errorf("timeout must be a duration such as 30s or 5m")
+	invalidRetry           = fmt.Errorf("retry must be a number of attempts or a map of retry settings")
+	invalidEnvType         = fmt.Errorf("env must be a map of environment variables")
+	invalidInterpreter     = fmt.Errorf("interpreter must be a command such as \"bash -c\" or a list of args")
+	invalidExecType        = fmt.Errorf("exec must be a list of args")
+	invalidGenerates       = fmt.Errorf("generates needs sources to compare its files against")
 )
 
 func parseBytes(contents []byte) (*ast, error) {
+	return parseFile("", contents)
+}
+
+// parse the contents of a Taskies file, keeping track of where in file
+// its instructions and run items are
+func parseFile(file string, contents []byte) (*ast, error) {
 	var yaml interface{}
 
 	err := goyaml.Unmarshal(contents, &yaml)
 
 	if err != nil {
-		return nil, err
+		return nil, yamlError(file, err)
 	}
 
 	yaml = clean(yaml)
-	return parseYaml(yaml)
+	ast, err := parseYaml(yaml, scanPositions(file, contents))
+
+	if err != nil {
+		return nil, pos{file: file}.wrap(err)
+	}
+
+	return ast, nil
 }
 
-func parseYaml(data interface{}) (*ast, error) {
+var yamlErrorLine = regexp.MustCompile(`^(?:YAML error|yaml): line (\d+): (.*)$`)
+
+// move the line goyaml reports an error at in front of the error, along
+// with the file
+func yamlError(file string, err error) error {
+	if m := yamlErrorLine.FindStringSubmatch(err.Error()); m != nil {
+		line, _ := strconv.Atoi(m[1])
+		return pos{file: file, line: line}.wrap(errors.New(m[2]))
+	}
+
+	return pos{file: file}.wrap(err)
+}
+
+func parseYaml(data interface{}, root *posNode) (*ast, error) {
 	ast := &ast{
 		instructions: make([]instruction, 0),
 	}
 
-	if err := ast.decode(reflect.ValueOf(data)); err != nil {
+	if err := ast.decode(reflect.ValueOf(data), root); err != nil {
 		return nil, err
 	}
 
@@ -47,7 +83,7 @@ type ast struct {
 	instructions []instruction
 }
 
-func (a *ast) decode(data reflect.Value) error {
+func (a *ast) decode(data reflect.Value, root *posNode) error {
 	k := data.Kind()
 
 	if k != reflect.Slice {
@@ -59,7 +95,7 @@ func (a *ast) decode(data reflect.Value) error {
 	for i := 0; i < l; i++ {
 		v := data.Index(i).Elem()
 
-		if err := a.decodeInstruction(v); err != nil {
+		if err := a.decodeInstruction(v, root.item(i)); err != nil {
 			return err
 		}
 	}
@@ -67,7 +103,7 @@ func (a *ast) decode(data reflect.Value) error {
 	return nil
 }
 
-func (a *ast) decodeInstruction(r reflect.Value) error {
+func (a *ast) decodeInstruction(r reflect.Value, n *posNode) error {
 	k := r.Kind()
 
 	var (
@@ -80,22 +116,27 @@ func (a *ast) decodeInstruction(r reflect.Value) error {
 		val = r
 	} else {
 		if k != reflect.Map {
-			return invalidInstructionType
+			return n.position().wrap(invalidInstructionType)
 		}
 
 		if r.Len() != 1 {
-			return invalidInstructionLen
+			return n.position().wrap(invalidInstructionLen)
 		}
 
 		k := r.MapKeys()[0]
 		key = k.String()
 		val = r.MapIndex(k).Elem()
+		n = n.key(key)
 	}
 
 	ins, err := decodeInstruction(key, val)
 
 	if err != nil {
-		return err
+		return locateError(n, err)
+	}
+
+	if l, ok := ins.(locatable); ok {
+		l.locate(n)
 	}
 
 	a.instructions = append(a.instructions, ins)
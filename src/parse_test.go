@@ -223,3 +223,263 @@ func TestParseSet(t *testing.T) {
 		}
 	}
 }
+
+func TestParseDeps(t *testing.T) {
+	yaml := []byte(`
+- task:
+    name: hello
+    deps: [one, two]
+    shell: ls -l
+`)
+	ast, err := parseBytes(yaml)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task := ast.instructions[0].(*defineTask)
+
+	if len(task.opts.deps) != 2 || task.opts.deps[0] != "one" || task.opts.deps[1] != "two" {
+		t.Fatalf("expect deps to be [one two], found %v", task.opts.deps)
+	}
+
+	if len(task.runList.tasks) != 1 {
+		t.Fatal("expect deps not to be decoded as a task")
+	}
+}
+
+func TestParseParams(t *testing.T) {
+	yaml := []byte(`
+- task:
+    name: hello
+    params:
+      - name
+      - name: count
+        type: int
+        default: 3
+        required: true
+        description: how many
+    shell: ls -l
+`)
+	ast, err := parseBytes(yaml)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := ast.instructions[0].(*defineTask).opts.params
+
+	if len(params) != 2 {
+		t.Fatalf("expect 2 params, found %d", len(params))
+	}
+
+	if params[0].name != "name" || params[0].typ != "string" {
+		t.Fatal("expect first param to be a string called name")
+	}
+
+	p := params[1]
+
+	if p.name != "count" || p.typ != "int" || p.def != 3 || !p.required || p.description != "how many" {
+		t.Fatalf("unexpected param %#v", p)
+	}
+
+	_, err = parseBytes([]byte(`
+- task:
+    name: hello
+    params:
+      - name: count
+        type: int
+        default: many
+`))
+
+	if err == nil {
+		t.Fatal("expect invalid default to fail")
+	}
+}
+
+func TestParseExpr(t *testing.T) {
+	e := NewEnv()
+	e.SetVar("a", "hello world")
+	e.SetVar("n", "5")
+
+	tests := map[string]bool{
+		"{{a}} == 'hello world'":          true,
+		"{{n}} < 10":                      true,
+		"{{n}} > 10 || {{a}}":             true,
+		"!({{n}} == 5) && true":           false,
+		"{{missing}}":                     false,
+		"off":                             false,
+		"abc < abd":                       true,
+		"{{n}} >= 5 && {{n}} <= 5 && yes": true,
+	}
+
+	for s, expect := range tests {
+		x, err := parseExpr(s)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if truthy(x.eval(e)) != expect {
+			t.Fatalf("expect %s to be %v", s, expect)
+		}
+	}
+
+	for _, s := range []string{"a ==", "(a", "a b", "'a"} {
+		if _, err := parseExpr(s); err == nil {
+			t.Fatalf("expect %s to fail to parse", s)
+		}
+	}
+}
+
+func TestParsePositions(t *testing.T) {
+	ast, err := parseFile("Taskies", []byte(`# build tasks
+- set:
+    a: b
+
+- task:
+    name: build
+    run:
+      - shell: |
+          # not a comment
+          echo build
+      - compile
+      - { task: lint, args: [1, 2] }
+
+- deploy: { env: prod }
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	def := ast.instructions[1].(*defineTask)
+
+	if p := def.pos.String(); p != "Taskies:5:3" {
+		t.Fatalf("Unexpected task position %s", p)
+	}
+
+	expect := []string{"Taskies:8:9", "Taskies:11:9", "Taskies:12:9"}
+
+	for i, rt := range def.runList.tasks {
+		if p := rt.pos.String(); p != expect[i] {
+			t.Fatalf("Expected run item %d at %s, found %s", i, expect[i], p)
+		}
+	}
+
+	if p := ast.instructions[2].(*runTasks).tasks[0].pos.String(); p != "Taskies:14:3" {
+		t.Fatalf("Unexpected run position %s", p)
+	}
+}
+
+func TestParseErrorPositions(t *testing.T) {
+	tests := map[string]string{
+		`
+- task:
+    name: build
+    timeout: soon
+`: "Taskies:4:5: " + invalidTimeout.Error(),
+		`
+- task:
+    name: build
+    run:
+      - shell: echo build
+      - task: a
+        ignore_error: maybe
+`: "Taskies:7:9: ignore_error must be a boolean",
+		`
+- set: a
+`: "Taskies:2:3: " + invalidSetType.Error(),
+		`
+- a: b
+  c: d
+`: "Taskies:2:3: " + invalidInstructionLen.Error(),
+		`
+- include:
+    - { path: ./a, as: a, chdir: maybe }
+`: "Taskies:3:27: chdir of include must be a boolean",
+		`
+- task:
+    name: build
+    generates: out.bin
+    shell: make
+`: "Taskies:4:5: " + invalidGenerates.Error(),
+		`a: b`: "Taskies: " + invalidTopLevelType.Error(),
+	}
+
+	for yaml, expect := range tests {
+		_, err := parseFile("Taskies", []byte(yaml))
+
+		if err == nil || err.Error() != expect {
+			t.Fatalf("Expected %#v, got %v", expect, err)
+		}
+
+		if _, ok := err.(*SourceError); !ok {
+			t.Fatalf("Expected a SourceError, got %#v", err)
+		}
+	}
+
+	_, err := parseFile("Taskies", []byte("- task: [\n"))
+
+	if se, ok := err.(*SourceError); !ok || se.File != "Taskies" || se.Line == 0 {
+		t.Fatalf("Expected the yaml error to be located, got %v", err)
+	}
+}
+
+func TestScanPositionsUnsupported(t *testing.T) {
+	// paths mapped to where they are, "" when the scanner doesn't follow
+	// the YAML they are in and so must not guess
+	tests := map[string]map[string][]interface{}{
+		`
+- task: &build
+    name: build
+- task: *build
+`: {
+			"Taskies:2:3": {0, "task"},
+			"":            {0, "task", "name"},
+			"Taskies:4:3": {1, "task"},
+		},
+		`
+- task:
+    <<: *defaults
+    name: build
+`: {
+			"":            {0, "task", "<<"},
+			"Taskies:4:5": {0, "task", "name"},
+		},
+		`
+- !!map
+  task: build
+- shell: echo
+`: {
+			"Taskies:2:3": {0},
+			"":            {0, "task"},
+			"Taskies:4:3": {1, "shell"},
+		},
+		`
+- deps: [a,
+    b]
+- shell: echo
+`: {
+			"Taskies:2:3": {0, "deps"},
+			"":            {0, "deps", 1},
+			"Taskies:4:3": {1, "shell"},
+		},
+		`
+- { task: *lint, args: [1, 2] }
+`: {
+			"Taskies:2:3": {0},
+			"":            {0, "args"},
+		},
+	}
+
+	for yaml, paths := range tests {
+		n := scanPositions("Taskies", []byte(yaml))
+
+		for expect, path := range paths {
+			if p := n.at(path).position().String(); p != expect {
+				t.Fatalf("Expected %v at %#v in\n%s\nfound %#v", path, expect, yaml, p)
+			}
+		}
+	}
+}
@@ -0,0 +1,520 @@
+package src
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// position of a node in a Taskies file. Lines and cols start at 1, a
+// zero line or col means it isn't known
+type pos struct {
+	file string
+	line int
+	col  int
+}
+
+func (p pos) String() string {
+	parts := make([]string, 0, 3)
+
+	if p.file != "" {
+		parts = append(parts, displayPath(p.file))
+	}
+
+	if p.line > 0 {
+		parts = append(parts, strconv.Itoa(p.line))
+
+		if p.col > 0 {
+			parts = append(parts, strconv.Itoa(p.col))
+		}
+	}
+
+	return strings.Join(parts, ":")
+}
+
+func (p pos) known() bool {
+	return p.line > 0
+}
+
+// Error caused by the Taskies file at File. Line and Col are 0 when the
+// exact position isn't known
+type SourceError struct {
+	File string
+	Line int
+	Col  int
+	Err  error
+}
+
+func (e *SourceError) Error() string {
+	return fmt.Sprintf("%s: %s", pos{e.File, e.Line, e.Col}, e.Err)
+}
+
+func (e *SourceError) Unwrap() error {
+	return e.Err
+}
+
+// locate err at p, unless it already has a position or p is unknown
+func (p pos) wrap(err error) error {
+	if _, ok := err.(*SourceError); ok || err == nil || (p.file == "" && !p.known()) {
+		return err
+	}
+
+	return &SourceError{p.file, p.line, p.col, err}
+}
+
+func (p pos) errorf(format string, args ...interface{}) error {
+	return p.wrap(fmt.Errorf(format, args...))
+}
+
+// error found while decoding the value at path, made of keys and item
+// indexes, below the value being decoded
+type decodeError struct {
+	path []interface{}
+	err  error
+}
+
+func (e *decodeError) Error() string {
+	return e.err.Error()
+}
+
+// mark err as found while decoding the value at key, a map key or an item
+// index
+func errAt(key interface{}, err error) error {
+	if de, ok := err.(*decodeError); ok {
+		return &decodeError{append([]interface{}{key}, de.path...), de.err}
+	}
+
+	if err == nil {
+		return nil
+	}
+
+	return &decodeError{[]interface{}{key}, err}
+}
+
+// locate an error returned by the decoder of the value at n
+func locateError(n *posNode, err error) error {
+	if de, ok := err.(*decodeError); ok {
+		return n.near(de.path).wrap(de.err)
+	}
+
+	return n.position().wrap(err)
+}
+
+// instructions which keep track of where they are in their file
+type locatable interface {
+	locate(*posNode)
+}
+
+// where a task was declared, looking through the options it is wrapped
+// with
+func taskPos(t Task) pos {
+	if ot, ok := t.(*optionsTask); ok {
+		return taskPos(ot.Task)
+	}
+
+	if p, ok := t.(interface{ position() pos }); ok {
+		return p.position()
+	}
+
+	return pos{}
+}
+
+// positions of the nodes of a YAML document, laid out like the document
+// goyaml decodes. The position of a mapping value is that of its key.
+// goyaml doesn't report positions, so they are recovered by scanning the
+// raw text, which only knows about block collections and single line
+// flow collections. It doesn't follow anchors, aliases, tags, merge keys
+// or flow collections spanning lines: the nodes below them are missing
+// rather than guessed, as are any it can't make sense of, so every
+// accessor is safe to call on nil
+type posNode struct {
+	pos
+	keys  map[string]*posNode
+	items []*posNode
+}
+
+func (n *posNode) key(k string) *posNode {
+	if n == nil {
+		return nil
+	}
+
+	return n.keys[k]
+}
+
+func (n *posNode) item(i int) *posNode {
+	if n == nil || i < 0 || i >= len(n.items) {
+		return nil
+	}
+
+	return n.items[i]
+}
+
+// the node at path, made of keys and item indexes
+func (n *posNode) at(path []interface{}) *posNode {
+	for _, p := range path {
+		switch k := p.(type) {
+		case string:
+			n = n.key(k)
+		case int:
+			n = n.item(k)
+		}
+	}
+
+	return n
+}
+
+// the position of the deepest node along path which is known
+func (n *posNode) near(path []interface{}) pos {
+	p := n.position()
+
+	for i := range path {
+		if n2 := n.at(path[:i+1]); n2.position().known() {
+			p = n2.pos
+		}
+	}
+
+	return p
+}
+
+func (n *posNode) position() pos {
+	if n == nil {
+		return pos{}
+	}
+
+	return n.pos
+}
+
+func scanPositions(file string, raw []byte) *posNode {
+	s := &posScanner{
+		file:  file,
+		lines: strings.Split(strings.Replace(string(raw), "\r\n", "\n", -1), "\n"),
+		li:    -1,
+	}
+
+	s.nextLine()
+
+	if s.ok() && strings.HasPrefix(s.frag(), "---") {
+		s.nextLine()
+	}
+
+	return s.node(-1)
+}
+
+// reads the fragment of lines[li] starting at col
+type posScanner struct {
+	file  string
+	lines []string
+	li    int
+	col   int
+}
+
+func (s *posScanner) ok() bool {
+	return s.li < len(s.lines)
+}
+
+func (s *posScanner) frag() string {
+	return strings.TrimRight(s.lines[s.li][s.col:], " \t")
+}
+
+func (s *posScanner) here() *posNode {
+	return &posNode{pos: pos{s.file, s.li + 1, s.col + 1}}
+}
+
+// move to the first non blank, non comment line after the current one
+func (s *posScanner) nextLine() {
+	for s.li++; s.ok(); s.li++ {
+		line := s.lines[s.li]
+		trimmed := strings.TrimLeft(line, " ")
+
+		if trimmed != "" && trimmed[0] != '#' && strings.TrimSpace(trimmed) != "" {
+			s.col = len(line) - len(trimmed)
+			return
+		}
+	}
+}
+
+// skip the lines of a value which are indented further than parent
+func (s *posScanner) skipNested(parent int) {
+	for s.nextLine(); s.ok() && s.col > parent; s.nextLine() {
+	}
+}
+
+// whether c starts an anchor, alias or tag
+func isNodeProperty(c byte) bool {
+	return c == '&' || c == '*' || c == '!'
+}
+
+func isSeqItem(f string) bool {
+	return f == "-" || strings.HasPrefix(f, "- ")
+}
+
+// the key of a "key: value" fragment, and the offset of its value
+func mapKey(f string) (string, int, bool) {
+	if f == "" || isSeqItem(f) || strings.ContainsRune("[{|>#&*!%@`", rune(f[0])) {
+		return "", 0, false
+	}
+
+	if f[0] == '"' || f[0] == '\'' {
+		end := strings.IndexByte(f[1:], f[0])
+
+		if end < 0 {
+			return "", 0, false
+		}
+
+		rest := f[end+2:]
+		trimmed := strings.TrimLeft(rest, " ")
+
+		if !strings.HasPrefix(trimmed, ":") || (len(trimmed) > 1 && trimmed[1] != ' ') {
+			return "", 0, false
+		}
+
+		return f[1 : end+1], len(f) - len(trimmed) + 1, true
+	}
+
+	i := strings.Index(f, ": ")
+
+	if i < 0 {
+		if !strings.HasSuffix(f, ":") {
+			return "", 0, false
+		}
+
+		i = len(f) - 1
+	}
+
+	if strings.Contains(f[:i], " #") {
+		return "", 0, false
+	}
+
+	return strings.TrimSpace(f[:i]), i + 1, true
+}
+
+func (s *posScanner) node(parent int) *posNode {
+	if !s.ok() || s.col <= parent {
+		return nil
+	}
+
+	f := s.frag()
+
+	if isSeqItem(f) {
+		return s.seq()
+	}
+
+	if _, _, ok := mapKey(f); ok {
+		return s.mapping()
+	}
+
+	return s.scalar(parent)
+}
+
+func (s *posScanner) seq() *posNode {
+	n := s.here()
+	col := s.col
+
+	for s.ok() && s.col == col && isSeqItem(s.frag()) {
+		rest := s.frag()[1:]
+		value := strings.TrimLeft(rest, " ")
+
+		if value == "" || value[0] == '#' {
+			empty := s.here()
+			s.nextLine()
+			item := s.node(col)
+
+			if item == nil {
+				item = empty
+			}
+
+			n.items = append(n.items, item)
+			continue
+		}
+
+		s.col += 1 + len(rest) - len(value)
+		n.items = append(n.items, s.node(col))
+	}
+
+	return n
+}
+
+func (s *posScanner) mapping() *posNode {
+	n := s.here()
+	n.keys = make(map[string]*posNode)
+	col := s.col
+
+	for s.ok() && s.col == col {
+		k, off, ok := mapKey(s.frag())
+
+		if !ok {
+			break
+		}
+
+		key := s.here()
+		rest := s.frag()[off:]
+		value := strings.TrimLeft(rest, " ")
+
+		var child *posNode
+
+		if value == "" || value[0] == '#' {
+			s.nextLine()
+
+			// a sequence may sit at the same indentation as its key
+			if s.ok() && s.col == col && isSeqItem(s.frag()) {
+				child = s.seq()
+			} else {
+				child = s.node(col)
+			}
+		} else {
+			s.col += off + len(rest) - len(value)
+			child = s.scalar(col)
+		}
+
+		if child == nil {
+			child = &posNode{}
+		}
+
+		child.pos = key.pos
+
+		// the keys goyaml merges in aren't in the text
+		if k != "<<" {
+			n.keys[k] = child
+		}
+	}
+
+	return n
+}
+
+// a scalar, block scalar or flow collection starting at the current
+// fragment. Values with an anchor, alias or tag are taken as scalars, the
+// nodes below them being skipped
+func (s *posScanner) scalar(parent int) *posNode {
+	n := s.here()
+	f := s.frag()
+
+	if f != "" && (f[0] == '[' || f[0] == '{') {
+		if flow, end := s.flow(s.lines[s.li], s.col); end > 0 {
+			n = flow
+		}
+	}
+
+	s.skipNested(parent)
+
+	return n
+}
+
+// parse the flow collection starting at line[i] when it closes on the
+// same line. Returns the index after it, or 0 if it doesn't close
+func (s *posScanner) flow(line string, i int) (*posNode, int) {
+	n := &posNode{pos: pos{s.file, s.li + 1, i + 1}}
+	isMap := line[i] == '{'
+	close := byte(']')
+
+	if isMap {
+		close = '}'
+		n.keys = make(map[string]*posNode)
+	}
+
+	i++
+
+	for i < len(line) {
+		for i < len(line) && (line[i] == ' ' || line[i] == ',') {
+			i++
+		}
+
+		if i >= len(line) {
+			return nil, 0
+		}
+
+		if line[i] == close {
+			return n, i + 1
+		}
+
+		if isNodeProperty(line[i]) {
+			return nil, 0
+		}
+
+		start := i
+		var child *posNode
+
+		if line[i] == '[' || line[i] == '{' {
+			c, end := s.flow(line, i)
+
+			if end == 0 {
+				return nil, 0
+			}
+
+			child, i = c, end
+		} else {
+			end := s.flowScalarEnd(line, i, isMap)
+
+			if end < 0 {
+				return nil, 0
+			}
+
+			child, i = &posNode{pos: pos{s.file, s.li + 1, start + 1}}, end
+		}
+
+		if !isMap {
+			n.items = append(n.items, child)
+			continue
+		}
+
+		key := strings.Trim(strings.TrimSpace(line[start:i]), `"'`)
+
+		for i < len(line) && line[i] == ' ' {
+			i++
+		}
+
+		if i < len(line) && line[i] == ':' {
+			i++
+
+			for i < len(line) && line[i] == ' ' {
+				i++
+			}
+
+			if i < len(line) && isNodeProperty(line[i]) {
+				return nil, 0
+			} else if i < len(line) && (line[i] == '[' || line[i] == '{') {
+				c, end := s.flow(line, i)
+
+				if end == 0 {
+					return nil, 0
+				}
+
+				child.keys, child.items, i = c.keys, c.items, end
+			} else if end := s.flowScalarEnd(line, i, false); end >= 0 {
+				i = end
+			} else {
+				return nil, 0
+			}
+		}
+
+		n.keys[key] = child
+	}
+
+	return nil, 0
+}
+
+// index of the end of the flow scalar at line[i], -1 if it doesn't end
+// on this line. Keys end at their colon
+func (s *posScanner) flowScalarEnd(line string, i int, key bool) int {
+	if line[i] == '"' || line[i] == '\'' {
+		end := strings.IndexByte(line[i+1:], line[i])
+
+		if end < 0 {
+			return -1
+		}
+
+		return i + end + 2
+	}
+
+	for ; i < len(line); i++ {
+		switch line[i] {
+		case ',', ']', '}':
+			return i
+		case ':':
+			if key && (i+1 == len(line) || line[i+1] == ' ') {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package src
+
+import (
+	"os/exec"
+)
+
+func killGroupOnCancel(c *exec.Cmd) {
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package src
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// run the command in its own process group and kill the whole group
+// when it is cancelled, so children of sh don't outlive it
+func killGroupOnCancel(c *exec.Cmd) {
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	c.Cancel = func() error {
+		return syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,135 @@
+package src
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// how often and when a failing task is run again
+type retryPolicy struct {
+	attempts  int
+	delay     time.Duration
+	backoff   float64
+	exitCodes []int
+}
+
+// decode either a number of attempts or a map of
+// { attempts, delay, backoff, on_exit_codes }
+func decodeRetry(data reflect.Value) (*retryPolicy, error) {
+	p := &retryPolicy{
+		attempts: 1,
+		backoff:  1,
+	}
+
+	if data.Kind() == reflect.String {
+		attempts, err := decodeInt(data)
+
+		if err != nil {
+			return nil, invalidRetry
+		}
+
+		p.attempts = attempts
+		return p, nil
+	}
+
+	if data.Kind() != reflect.Map {
+		return nil, invalidRetry
+	}
+
+	for _, k := range data.MapKeys() {
+		v := data.MapIndex(k).Elem()
+
+		switch k.String() {
+		case "attempts":
+			attempts, err := decodeInt(v)
+
+			if err != nil {
+				return nil, fmt.Errorf("retry attempts must be an integer")
+			}
+
+			p.attempts = attempts
+		case "delay":
+			delay, err := decodeDuration(v)
+
+			if err != nil {
+				return nil, fmt.Errorf("retry delay must be a duration such as 500ms or 2s")
+			}
+
+			p.delay = delay
+		case "backoff":
+			backoff, err := strconv.ParseFloat(v.String(), 64)
+
+			if err != nil || backoff < 1 {
+				return nil, fmt.Errorf("retry backoff must be a number no smaller than 1")
+			}
+
+			p.backoff = backoff
+		case "on_exit_codes":
+			codes, err := decodeStrings(v)
+
+			if err != nil {
+				return nil, fmt.Errorf("retry on_exit_codes must be a list of integers")
+			}
+
+			for _, code := range codes {
+				i, err := strconv.Atoi(code)
+
+				if err != nil {
+					return nil, fmt.Errorf("retry on_exit_codes must be a list of integers")
+				}
+
+				p.exitCodes = append(p.exitCodes, i)
+			}
+		default:
+			return nil, fmt.Errorf("Invalid retry key \"%s\"", k.String())
+		}
+	}
+
+	return p, nil
+}
+
+// whether a task which failed with err on the given attempt should run
+// again
+func (p *retryPolicy) retries(attempt int, err error) bool {
+	if attempt >= p.attempts {
+		return false
+	}
+
+	if len(p.exitCodes) == 0 {
+		return true
+	}
+
+	code, ok := exitCode(err)
+
+	if !ok {
+		return false
+	}
+
+	for _, c := range p.exitCodes {
+		if c == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// wait before the attempt following the given one. Returns false if ctx
+// was cancelled while waiting
+func (p *retryPolicy) wait(ctx context.Context, attempt int) bool {
+	delay := float64(p.delay)
+
+	for i := 1; i < attempt; i++ {
+		delay *= p.backoff
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(time.Duration(delay)):
+		return true
+	}
+}
@@ -2,11 +2,14 @@ package src
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 	"unicode"
 )
 
@@ -17,26 +20,56 @@ var (
 
 func LoadRuntime(path string, in io.Reader, out, err io.Writer) (*Runtime, error) {
 	rt := newRuntime(in, out, err)
+	rt.path = path
 
-	ns, ast, loaded, e := rt.nsg.load(path)
-
-	if e != nil {
+	if e := rt.load(); e != nil {
 		return nil, e
 	}
 
-	rt.ns = ns
+	return rt, nil
+}
 
-	if !loaded {
-		e := inDir(filepath.Dir(path), func() error {
-			return execAst(rt, ns, ns.RootEnv(), ast)
-		})
+// (re)load the runtime's file and everything it includes from scratch.
+// Tasks already running keep using the namespaces they were loaded from
+func (r *Runtime) load() error {
+	r.loadLock.Lock()
+	defer r.loadLock.Unlock()
 
-		if e != nil {
-			return nil, e
+	nsg := r.nsg
+	r.nsg = newNsGroup(newLoader())
+
+	ns, ast, loaded, e := r.nsg.load(r.path, false)
+
+	if e == nil && !loaded {
+		if e = r.nsg.enter(ns.Id()); e == nil {
+			e = execAst(r, ns, ns.RootEnv(), ast)
+			r.nsg.leave()
 		}
 	}
 
-	return rt, nil
+	if e != nil {
+		r.nsg = nsg
+		return e
+	}
+
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	r.ns = ns
+
+	for k, v := range r.vars {
+		ns.RootEnv().SetVar(k, v)
+	}
+
+	return nil
+}
+
+// paths of every file loaded by the runtime
+func (r *Runtime) loadedPaths() []string {
+	r.loadLock.Lock()
+	defer r.loadLock.Unlock()
+
+	return r.nsg.loader.paths()
 }
 
 func NewRuntime(in io.Reader, out, err io.Writer) *Runtime {
@@ -54,20 +87,44 @@ func newRuntime(in io.Reader, out, err io.Writer) *Runtime {
 	nsg := newNsGroup(loader)
 
 	return &Runtime{
-		nsg: nsg,
-		in:  in,
-		out: out,
-		err: err,
+		nsg:  nsg,
+		in:   in,
+		out:  out,
+		err:  err,
+		vars: make(map[string]interface{}),
 	}
 }
 
 type Runtime struct {
+	path    string
+	vars    map[string]interface{}
 	ns      Namespace
 	nsg     *nsGroup
 	in      io.Reader
 	out     io.Writer
 	err     io.Writer
 	Watcher Watcher
+
+	// Default number of tasks a parallel block runs at once when it
+	// doesn't set max_concurrency. 0 means no limit
+	Jobs int
+
+	// Print the commands that would run instead of running them
+	DryRun bool
+
+	// Export every var to the environment of commands as TASKIES_*,
+	// e.g. TASKS.build.OUT as TASKIES_TASKS_BUILD_OUT
+	ExportVars bool
+
+	// Fail tasks whose templates use vars which aren't set, unless they
+	// mark them optional like {{var?}}. Files can also turn it on with
+	// a strict instruction
+	Strict bool
+
+	// l guards ns and vars, loadLock serializes loads and guards nsg
+	l        sync.RWMutex
+	loadLock sync.Mutex
+	outLock  sync.Mutex
 }
 
 func (r *Runtime) In() io.Reader {
@@ -83,50 +140,247 @@ func (r *Runtime) Err() io.Writer {
 }
 
 func (r *Runtime) Run(task string) error {
-	t, _ := r.ns.RootEnv().GetTask(task)
+	return r.RunWithContext(context.Background(), task)
+}
+
+// Run a task, cancelling it once ctx is done
+func (r *Runtime) RunWithContext(ctx context.Context, task string) error {
+	root := r.RootNs()
+	t := r.getTask(root, task)
 
 	if t == nil {
 		return MissingTask
 	}
 
-	return r.runWithDefaults(t)
+	return r.runWithContext(ctx, root.RootEnv(), t)
 }
 
-func (r *Runtime) RootNs() Namespace {
-	return r.ns
+// Look up a task by name. Tasks of included namespaces are qualified by
+// the alias they were included as, such as "other.Hello" or
+// "other:Hello", and only their exported tasks can be reached
+func (r *Runtime) GetTask(name string) Task {
+	return r.getTask(r.RootNs(), name)
 }
 
-func (r *Runtime) runWithDefaults(t Task) error {
-	return r.run(t, r.ns.RootEnv(), r.In(), r.Out(), r.Err())
+func (r *Runtime) getTask(root Namespace, name string) Task {
+	name = strings.Replace(name, ":", ".", -1)
+	i := strings.LastIndex(name, ".")
+
+	if i < 0 {
+		t, _ := root.RootEnv().GetTask(name)
+		return t
+	}
+
+	vs, ok := root.RootEnv().GetVar(name[:i]).(*varSet)
+
+	if !ok {
+		return nil
+	}
+
+	for _, ns := range r.namespaces() {
+		if ns.RootEnv().vars == vs {
+			return ns.GetTask(name[i+1:])
+		}
+	}
+
+	return nil
 }
 
-func (r *Runtime) run(t Task, env *Env, in io.Reader, out, err io.Writer) error {
-	name := t.Name()
+// Names of the exported tasks of the root namespace, followed by those of
+// the included namespaces qualified by their alias
+func (r *Runtime) Tasks() []string {
+	root := r.RootNs()
+	byVars := make(map[*varSet]Namespace)
 
-	if name == "" {
-		name = t.Type()
+	for _, ns := range r.namespaces() {
+		byVars[ns.RootEnv().vars] = ns
 	}
 
-	if t2 := env.GetVar("TASKS." + name); t2 != nil {
-		i := 1
+	names := append([]string{}, root.Tasks()...)
+	seen := map[*varSet]bool{root.RootEnv().vars: true}
 
-		for {
-			n := fmt.Sprintf("%s_%d", name, i)
+	return includedTasks(root.RootEnv().vars, "", byVars, seen, names)
+}
 
-			if t2 := env.GetVar("TASKS." + n); t2 == nil {
-				name = n
-				break
+func includedTasks(v interface{}, prefix string, byVars map[*varSet]Namespace, seen map[*varSet]bool, names []string) []string {
+	vals := make(map[string]interface{})
+
+	switch val := v.(type) {
+	case *varSet:
+		val.l.RLock()
+
+		for k, v2 := range val.vals {
+			vals[k] = v2
+		}
+
+		val.l.RUnlock()
+	case map[string]interface{}:
+		vals = val
+	}
+
+	keys := make([]string, 0, len(vals))
+
+	for k := range vals {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		switch val := vals[k].(type) {
+		case *varSet:
+			ns, ok := byVars[val]
+
+			if !ok || seen[val] {
+				continue
 			}
 
-			i++
+			seen[val] = true
+
+			for _, name := range ns.Tasks() {
+				names = append(names, prefix+k+"."+name)
+			}
+
+			names = includedTasks(val, prefix+k+".", byVars, seen, names)
+		case map[string]interface{}:
+			names = includedTasks(val, prefix+k+".", byVars, seen, names)
 		}
 	}
 
+	return names
+}
+
+// every namespace loaded by the runtime
+func (r *Runtime) namespaces() []Namespace {
+	r.loadLock.Lock()
+	defer r.loadLock.Unlock()
+
+	r.nsg.Lock()
+	defer r.nsg.Unlock()
+
+	nss := make([]Namespace, 0, len(r.nsg.ns))
+
+	for _, ns := range r.nsg.ns {
+		nss = append(nss, ns)
+	}
+
+	return nss
+}
+
+func (r *Runtime) RootNs() Namespace {
+	r.l.RLock()
+	defer r.l.RUnlock()
+
+	return r.ns
+}
+
+// Set a variable in the root namespace. Unlike setting it on the root
+// env directly, the variable is kept when the runtime reloads its files
+func (r *Runtime) SetVar(k string, v interface{}) {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	r.vars[k] = v
+	r.ns.RootEnv().SetVar(k, v)
+}
+
+// Set the args passed to a task on the command line as root vars. When
+// the task declares params, unknown args, values of the wrong type and
+// missing required params are reported before anything runs
+func (r *Runtime) SetArgs(task string, args map[string]string) error {
+	root := r.RootNs().RootEnv()
+	t := r.GetTask(task)
+
+	if t == nil {
+		return MissingTask
+	}
+
+	params := taskParams(t)
+	names := make([]string, 0, len(args))
+
+	for k, _ := range args {
+		names = append(names, k)
+	}
+
+	if err := checkParamNames(task, params, names); err != nil {
+		return err
+	}
+
+	env := root.Child()
+
+	for k, v := range args {
+		env.SetVar(k, v)
+	}
+
+	if err := applyParams(task, params, env); err != nil {
+		return err
+	}
+
+	for k, _ := range args {
+		r.SetVar(k, env.GetVar(k))
+	}
+
+	return nil
+}
+
+// run t while its file is loaded, from dir, the directory of that file
+func (r *Runtime) runWithDefaults(dir string, e *Env, t Task) error {
+	c := r.newContext(context.Background(), e)
+	c.dir = dir
+
+	return r.run(c, t)
+}
+
+func (r *Runtime) runWithContext(ctx context.Context, e *Env, t Task) error {
+	return r.run(r.newContext(ctx, e), t)
+}
+
+func (r *Runtime) newContext(ctx context.Context, e *Env) *runContext {
+	return &runContext{
+		in:    r.In(),
+		out:   r.Out(),
+		err:   r.Err(),
+		env:   e,
+		runfn: r.run,
+		once:  newOnceSet(),
+		ctx:   ctx,
+		rt:    r,
+	}
+}
+
+// types of the run items which run a command or a builtin themselves
+var commandTypes = map[string]bool{
+	"shell":    true,
+	"script":   true,
+	"exec":     true,
+	"mkdir":    true,
+	"copy":     true,
+	"move":     true,
+	"remove":   true,
+	"write":    true,
+	"template": true,
+}
+
+func (r *Runtime) run(c RunContext, t Task) error {
+	if err := c.Context().Err(); err != nil {
+		return err
+	}
+
+	env := c.Env()
+	name := t.Name()
+
+	if name == "" {
+		name = t.Type()
+	}
+
+	display := name
+	name = env.reserveResult(name)
+
 	bout := new(bytes.Buffer)
 	berr := new(bytes.Buffer)
 
-	sout := io.MultiWriter(out, bout)
-	serr := io.MultiWriter(err, berr)
+	sout := io.MultiWriter(c.Out(), bout)
+	serr := io.MultiWriter(c.Err(), berr)
 
 	cenv := env.Child()
 
@@ -134,16 +388,15 @@ func (r *Runtime) run(t Task, env *Env, in io.Reader, out, err io.Writer) error
 		cenv.addParent(t.Env())
 	}
 
-	var ctxt RunContext
+	ctxt := c.Clone(nil, sout, serr, cenv).(*runContext)
+
+	// anonymous shell steps are shown by their command alone
+	if t.Name() != "" || !commandTypes[t.Type()] {
+		if r.DryRun {
+			r.printDry(ctxt.depth, display)
+		}
 
-	ctxt = &context{
-		in:  in,
-		out: sout,
-		err: serr,
-		runfn: func(c RunContext, t2 Task) error {
-			return r.run(t2, c.Env(), c.In(), c.Out(), c.Err())
-		},
-		env: cenv,
+		ctxt.depth++
 	}
 
 	Debugf("[RUN TASK] [ENV=%s] %#v", cenv.Id(), t)
@@ -154,28 +407,60 @@ func (r *Runtime) run(t Task, env *Env, in io.Reader, out, err io.Writer) error
 		}
 	}
 
+	started := time.Now()
 	e := t.Run(ctxt)
+	finished := time.Now()
 
-	if e != nil {
-		cenv.SetVar("ERROR", e.Error())
+	nameTemplateError(t, e)
+
+	// an ignored error has already been recorded by the task
+	if e != nil || cenv.vars.Get("SUCCESS") == nil {
+		setResult(cenv, e)
 	}
 
-	cenv.SetVar("OUT", strings.TrimRightFunc(string(bout.Bytes()), unicode.IsSpace))
-	cenv.SetVar("ERR", strings.TrimRightFunc(string(berr.Bytes()), unicode.IsSpace))
+	cenv.SetVar("STARTED_AT", started.Format(time.RFC3339Nano))
+	cenv.SetVar("FINISHED_AT", finished.Format(time.RFC3339Nano))
+	cenv.SetVar("DURATION", finished.Sub(started).Round(time.Millisecond).Seconds())
+
+	if r.DryRun {
+		// nothing actually ran, so show where the output would be used
+		cenv.SetVar("OUT", "<"+name+".OUT>")
+		cenv.SetVar("ERR", "<"+name+".ERR>")
+	} else {
+		cenv.SetVar("OUT", strings.TrimRightFunc(string(bout.Bytes()), unicode.IsSpace))
+		cenv.SetVar("ERR", strings.TrimRightFunc(string(berr.Bytes()), unicode.IsSpace))
+	}
 
 	exp := t.Export()
+	strict := ctxt.Strict() || strictTask(t)
 
 	for _, vars := range exp {
+		// SetVar renders leniently, so check the vars first
+		if strict && e == nil {
+			if _, err := renderValue(vars, cenv, true); err != nil {
+				e = err
+				nameTemplateError(t, e)
+				setResult(cenv, e)
+				break
+			}
+		}
+
 		for k, v := range vars {
 			cenv.SetVar(k, v)
 		}
 	}
 
+	var result interface{} = cenv
+
+	if results, ok := eachResults(t, cenv); ok {
+		result = results
+	}
+
 	env.SetVar("LAST", cenv)
-	env.SetVar("TASKS."+name, cenv)
+	env.SetVar("TASKS."+name, result)
 
 	if t.Var() != "" {
-		env.SetVar(t.Var(), cenv)
+		env.SetVar(t.Var(), result)
 	}
 
 	if r.Watcher != nil {
@@ -187,6 +472,41 @@ func (r *Runtime) run(t Task, env *Env, in io.Reader, out, err io.Writer) error
 	return e
 }
 
+// name the innermost named task an undefined template var was used in
+func nameTemplateError(t Task, err error) {
+	var te *TemplateError
+
+	if t.Name() != "" && errors.As(err, &te) && te.Task == "" {
+		te.Task = t.Name()
+	}
+}
+
+// record how a run ended in its result env. EXIT_CODE is -1 for errors
+// which didn't come from a command exiting
+func setResult(e *Env, err error) {
+	code := 0
+
+	if err != nil {
+		e.SetVar("ERROR", err.Error())
+		code = -1
+
+		if c, ok := exitCode(err); ok {
+			code = c
+		}
+	}
+
+	e.SetVar("EXIT_CODE", code)
+	e.SetVar("SUCCESS", err == nil)
+}
+
+// print a line of the dry run tree
+func (r *Runtime) printDry(depth int, line string) {
+	r.outLock.Lock()
+	defer r.outLock.Unlock()
+
+	fmt.Fprintf(r.Out(), "%s%s\n", strings.Repeat("  ", depth), line)
+}
+
 func execAst(r *Runtime, ns Namespace, e *Env, a *ast) error {
 	for _, ins := range a.instructions {
 		if err := ins.exec(r, ns, e); err != nil {
@@ -194,10 +514,16 @@ func execAst(r *Runtime, ns Namespace, e *Env, a *ast) error {
 		}
 	}
 
-	return nil
+	return resolveAllDeps(e)
 }
 
 type Watcher interface {
 	BeforeRun(*Runtime, *Env, Task) chan bool
 	AfterRun(*Runtime, *Env, Task) chan bool
 }
+
+// Watchers implementing RetryWatcher are told about every failed attempt
+// of a task that is going to be run again
+type RetryWatcher interface {
+	Retry(*Runtime, *Env, Task, int, error) chan bool
+}
@@ -2,12 +2,14 @@ package src
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"io/ioutil"
 	"os"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 func rt(p string, in io.Reader) (*Runtime, error) {
@@ -506,3 +508,1802 @@ func TestTaskVar2(t *testing.T) {
         - shell: echo "{{LAST.c}}"
 `)}, "3\n10\n10", nil, "two")
 }
+
+func TestDeps(t *testing.T) {
+	testEquals(t, [][]byte{[]byte(`
+- task:
+    name: Test
+    deps: [b, c]
+    shell: echo test
+
+- task:
+    name: b
+    deps: a
+    shell: echo b
+
+- task:
+    name: c
+    deps: [a]
+    shell: echo c
+
+- task:
+    name: a
+    shell: echo a
+`)}, "a\nb\nc\ntest", nil, "Test")
+}
+
+func TestDepsCycle(t *testing.T) {
+	d, err := newTmpdir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer d.cleanup()
+
+	n, err := d.addFile([]byte(`
+- task:
+    name: a
+    deps: b
+    shell: echo a
+
+- task:
+    name: b
+    deps: a
+    shell: echo b
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = rt(n, nil)
+
+	if err == nil {
+		t.Fatal("Expected dependency cycle error")
+	}
+
+	if !strings.Contains(err.Error(), "a -> b -> a") {
+		t.Fatalf("Expected cycle in error, found %s", err)
+	}
+}
+
+func TestParallel(t *testing.T) {
+	testEquals(t, [][]byte{[]byte(`
+- task:
+    name: Test
+    parallel:
+      - shell: sleep 0.2; echo 1
+      - shell: echo 2
+`)}, "2\n1", nil)
+
+	testEquals(t, [][]byte{[]byte(`
+- task:
+    name: Test
+    max_concurrency: 1
+    parallel:
+      - shell: sleep 0.2; echo 1
+      - shell: echo 2
+`)}, "1\n2", nil)
+
+	testEquals(t, [][]byte{[]byte(`
+- parallel:
+    max_concurrency: 1
+    run:
+      - shell: sleep 0.2; echo 1
+      - shell: echo 2
+`)}, "1\n2", nil)
+}
+
+func TestParallelResults(t *testing.T) {
+	d, err := newTmpdir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer d.cleanup()
+
+	items := make([]string, 30)
+	refs := make([]string, 30)
+
+	for i := range items {
+		items[i] = "      - shell: echo " + strconv.Itoa(i)
+		refs[i] = "{{TASKS.P.TASKS.shell_" + strconv.Itoa(i) + ".OUT}}"
+	}
+
+	refs[0] = "{{TASKS.P.TASKS.shell.OUT}}"
+
+	n, err := d.addFile([]byte(`
+- task:
+    name: P
+    parallel:
+` + strings.Join(items, "\n") + `
+
+- task:
+    name: Test
+    run:
+      - P
+      - shell: echo "` + strings.Join(refs, " ") + `"
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := rt(n, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Run("Test"); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(r.Out().(*bytes.Buffer).String()), "\n")
+	results := strings.Fields(lines[len(lines)-1])
+	seen := make(map[string]bool)
+
+	for _, res := range results {
+		seen[res] = true
+	}
+
+	if len(results) != 30 || len(seen) != 30 {
+		t.Fatalf("Expected the results of all 30 parallel items, got %v", results)
+	}
+}
+
+func TestParallelCancel(t *testing.T) {
+	d, err := newTmpdir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer d.cleanup()
+
+	n, err := d.addFile([]byte(`
+- task:
+    name: Test
+    parallel:
+      - shell: sleep 0.1; exit 3
+      - shell: sleep 5; echo late
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := rt(n, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	err = r.Run("Test")
+
+	if err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Fatalf("Expected the failing task's error, found %v", err)
+	}
+
+	if time.Since(start) > 2*time.Second {
+		t.Fatal("Expected remaining tasks to be cancelled")
+	}
+
+	if strings.Contains(r.Out().(*bytes.Buffer).String(), "late") {
+		t.Fatal("Expected cancelled task not to finish")
+	}
+}
+
+func TestSourcesUpToDate(t *testing.T) {
+	d, err := newTmpdir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer d.cleanup()
+
+	in := d.dir + "/in.txt"
+	ioutil.WriteFile(in, []byte("1"), 0644)
+
+	n, err := d.addFile([]byte(`
+- task:
+    name: build
+    dir: ` + d.dir + `
+    sources: "*.txt"
+    generates: out.bin
+    shell: cp in.txt out.bin && echo built
+
+- task:
+    name: Test
+    run:
+      - build
+      - shell: echo "{{#LAST.SKIPPED}}skipped{{/LAST.SKIPPED}}"
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := rt(n, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := func(val string) {
+		buf := r.Out().(*bytes.Buffer)
+		defer buf.Reset()
+
+		if err := r.Run("Test"); err != nil {
+			t.Fatal(err)
+		}
+
+		if out := strings.TrimSpace(buf.String()); out != val {
+			t.Fatalf("Expecting %s, found %s", val, out)
+		}
+	}
+
+	expect("built")
+	expect("skipped")
+
+	future := time.Now().Add(time.Hour)
+	ioutil.WriteFile(in, []byte("2"), 0644)
+	os.Chtimes(in, future, future)
+
+	expect("built")
+	expect("skipped")
+
+	os.Remove(d.dir + "/out.bin")
+
+	expect("built")
+}
+
+func TestSourcesParams(t *testing.T) {
+	d, err := newTmpdir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(d.dir)
+
+	ioutil.WriteFile(d.dir+"/in.txt", []byte("1"), 0644)
+
+	n, err := d.addFile([]byte(`
+- task:
+    name: Build
+    dir: ` + d.dir + `
+    params: [target]
+    sources: "*.txt"
+    shell: echo "built {{target}}"
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := rt(n, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := func(target, val string) {
+		buf := r.Out().(*bytes.Buffer)
+		defer buf.Reset()
+
+		if err := r.SetArgs("Build", map[string]string{"target": target}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := r.Run("Build"); err != nil {
+			t.Fatal(err)
+		}
+
+		if out := strings.TrimSpace(buf.String()); out != val {
+			t.Fatalf("Expecting %#v, found %#v", val, out)
+		}
+	}
+
+	expect("linux", "built linux")
+	expect("linux", "")
+	expect("darwin", "built darwin")
+	expect("linux", "")
+}
+
+func TestSourcesStateDir(t *testing.T) {
+	d, err := newTmpdir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(d.dir)
+
+	os.MkdirAll(d.dir+"/proj/src", 0755)
+	ioutil.WriteFile(d.dir+"/proj/src/in.txt", []byte("1"), 0644)
+
+	ioutil.WriteFile(d.dir+"/proj/Taskies", []byte(`
+- task:
+    name: Build
+    sources: "src/*.txt"
+    shell: echo built
+
+- task:
+    name: Empty
+    sources: "*.missing"
+    shell: echo empty
+`), 0644)
+
+	r, err := rt(d.dir+"/proj/Taskies", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sources are relative to the Taskies file rather than the process
+	for i := 0; i < 2; i++ {
+		if err := r.Run("Build"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if out := r.Out().(*bytes.Buffer).String(); out != "built\n" {
+		t.Fatalf("Expected Build to be up to date the second time, got %#v", out)
+	}
+
+	if _, err := os.Stat(d.dir + "/proj/.taskies"); err != nil {
+		t.Fatalf("Expected state to be kept next to the Taskies file, got %s", err)
+	}
+
+	if err := r.Run("Empty"); err != nil {
+		t.Fatal(err)
+	}
+
+	if warn := r.Err().(*bytes.Buffer).String(); !strings.Contains(warn, `Warning: sources of task "Empty" match no files`) {
+		t.Fatalf("Expected a warning about sources matching no files, got %#v", warn)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	d, err := newTmpdir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(d.dir)
+
+	n, err := d.addFile([]byte(`
+- task:
+    name: build
+    shell: echo building > {{dir}}/built
+
+- task:
+    name: count
+    pipe:
+      - shell: cat {{dir}}/built
+      - shell: tr a-z A-Z
+      - shell: wc -c
+
+- task:
+    name: Deploy
+    run:
+      - build
+      - shell: echo "deploying {{LAST.OUT}}"
+      - count
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := rt(n, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.DryRun = true
+	r.SetVar("dir", d.dir)
+
+	if err := r.Run("Deploy"); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := "Deploy\n" +
+		"  build\n" +
+		"    sh -c 'echo building > " + d.dir + "/built'\n" +
+		"  sh -c 'echo \"deploying <build.OUT>\"'\n" +
+		"  count\n" +
+		"    sh -c 'cat " + d.dir + "/built'\n" +
+		"    |\n" +
+		"    sh -c 'tr a-z A-Z'\n" +
+		"    |\n" +
+		"    sh -c 'wc -c'\n"
+
+	if out := r.Out().(*bytes.Buffer).String(); out != expect {
+		t.Fatalf("Expecting %q, found %q", expect, out)
+	}
+
+	if _, err := os.Stat(d.dir + "/built"); err == nil {
+		t.Fatal("Expected dry run not to run any command")
+	}
+}
+
+func TestParams(t *testing.T) {
+	testEquals(t, [][]byte{[]byte(`
+- task:
+    name: deploy
+    params:
+      - name: replicas
+        type: int
+        default: 2
+      - name: verbose
+        type: bool
+        default: false
+      - name: targets
+        type: list
+    shell: echo {{replicas}}{{#verbose}} verbose{{/verbose}}{{#targets}} {{.}}{{/targets}}
+
+- task:
+    name: Test
+    run:
+      - deploy
+      - deploy: { replicas: 3, verbose: "true", targets: "a, b" }
+`)}, "2\n3 verbose a b", nil, "Test")
+}
+
+func TestParamTypes(t *testing.T) {
+	e := NewEnv()
+	e.SetVar("replicas", "3")
+	e.SetVar("verbose", "true")
+	e.SetVar("targets", "a, b")
+
+	c := e.Child()
+	params := []*param{
+		{name: "replicas", typ: "int"},
+		{name: "verbose", typ: "bool"},
+		{name: "targets", typ: "list"},
+	}
+
+	if err := applyParams("Test", params, c); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, ok := c.GetVar("replicas").(int); !ok || v != 3 {
+		t.Fatalf("Expected int 3, got %#v", c.GetVar("replicas"))
+	}
+
+	if v, ok := c.GetVar("verbose").(bool); !ok || !v {
+		t.Fatalf("Expected bool true, got %#v", c.GetVar("verbose"))
+	}
+
+	if v, ok := c.GetVar("targets").([]interface{}); !ok || len(v) != 2 {
+		t.Fatalf("Expected a list of 2 targets, got %#v", c.GetVar("targets"))
+	}
+}
+
+func TestParamsErrors(t *testing.T) {
+	d, err := newTmpdir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(d.dir)
+
+	load := func(yaml string) (*Runtime, error) {
+		n, err := d.addFile([]byte(yaml))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return rt(n, nil)
+	}
+
+	def := `
+- task:
+    name: Deploy
+    params:
+      - name: env
+        required: true
+      - name: replicas
+        type: int
+    shell: echo {{env}} {{replicas}}
+`
+
+	_, err = load(def + `
+- task:
+    name: Test
+    Deploy: { env: prod, replica: 3 }
+`)
+
+	if err == nil || !strings.Contains(err.Error(), `Unknown param "replica"`) {
+		t.Fatalf("Expected unknown param error, found %v", err)
+	}
+
+	r, err := load(def + `
+- task:
+    name: Test
+    Deploy: { replicas: 3 }
+`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Run("Test"); err == nil || !strings.Contains(err.Error(), `Missing required param "env"`) {
+		t.Fatalf("Expected missing param error, found %v", err)
+	}
+
+	if err := r.SetArgs("Deploy", map[string]string{"env": "prod", "replicas": "many"}); err == nil {
+		t.Fatal("Expected invalid int to be reported")
+	}
+
+	if err := r.SetArgs("Deploy", map[string]string{"replicas": "3"}); err == nil {
+		t.Fatal("Expected missing required param to be reported")
+	}
+
+	if err := r.SetArgs("Deploy", map[string]string{"env": "prod", "replicas": "3"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Run("Deploy"); err != nil {
+		t.Fatal(err)
+	}
+
+	if out := strings.TrimSpace(r.Out().(*bytes.Buffer).String()); out != "prod 3" {
+		t.Fatalf("Expecting prod 3, found %s", out)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	d, err := newTmpdir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(d.dir)
+
+	n, err := d.addFile([]byte(`
+- task:
+    name: Slow
+    timeout: 200ms
+    shell: (sleep 0.5; touch {{dir}}/late) & wait
+
+- task:
+    name: Item
+    run:
+      - shell: echo before
+      - shell: sleep 5
+        timeout: 0.2
+      - shell: echo after
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := rt(n, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.SetVar("dir", d.dir)
+
+	start := time.Now()
+	err = r.Run("Slow")
+
+	if err == nil || !strings.Contains(err.Error(), `Task "Slow" timed out after 200ms`) {
+		t.Fatalf("Expected timeout error, found %v", err)
+	}
+
+	err = r.Run("Item")
+
+	if err == nil || !strings.Contains(err.Error(), `Task "shell" timed out after 200ms`) {
+		t.Fatalf("Expected timeout error, found %v", err)
+	}
+
+	if time.Since(start) > 2*time.Second {
+		t.Fatal("Expected commands to be killed on timeout")
+	}
+
+	if out := strings.TrimSpace(r.Out().(*bytes.Buffer).String()); out != "before" {
+		t.Fatalf("Expecting before, found %s", out)
+	}
+
+	if errmsg := r.RootNs().RootEnv().GetVar("TASKS.Slow.ERROR"); errmsg == nil {
+		t.Fatal("Expected timeout to be stored in ERROR")
+	}
+
+	time.Sleep(time.Second)
+
+	if _, err := os.Stat(d.dir + "/late"); err == nil {
+		t.Fatal("Expected the process group to be killed")
+	}
+}
+
+type retryWatcher struct {
+	attempts []int
+}
+
+func (w *retryWatcher) BeforeRun(r *Runtime, e *Env, t Task) chan bool {
+	return nil
+}
+
+func (w *retryWatcher) AfterRun(r *Runtime, e *Env, t Task) chan bool {
+	return nil
+}
+
+func (w *retryWatcher) Retry(r *Runtime, e *Env, t Task, attempt int, err error) chan bool {
+	w.attempts = append(w.attempts, attempt)
+	return nil
+}
+
+func TestRetry(t *testing.T) {
+	d, err := newTmpdir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(d.dir)
+
+	n, err := d.addFile([]byte(`
+- task:
+    name: Flaky
+    retry: { attempts: 3, delay: 10ms, backoff: 2 }
+    shell: echo {{ATTEMPT}}; test {{ATTEMPT}} -eq 3
+
+- task:
+    name: Codes
+    run:
+      - shell: echo {{ATTEMPT}}; exit 2
+        retry: { attempts: 3, on_exit_codes: [1] }
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := rt(n, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := &retryWatcher{}
+	r.Watcher = w
+
+	if err := r.Run("Flaky"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(w.attempts) != 2 || w.attempts[0] != 1 || w.attempts[1] != 2 {
+		t.Fatalf("Expected two failed attempts to be reported, found %v", w.attempts)
+	}
+
+	if attempt := r.RootNs().RootEnv().GetVar("TASKS.Flaky.ATTEMPT"); attempt != 3 {
+		t.Fatalf("Expected ATTEMPT to be 3, found %v", attempt)
+	}
+
+	if err := r.Run("Codes"); err == nil {
+		t.Fatal("Expected exit code 2 not to be retried")
+	}
+
+	if out := strings.TrimSpace(r.Out().(*bytes.Buffer).String()); out != "1\n2\n3\n1" {
+		t.Fatalf("Expecting 1 2 3 1, found %q", out)
+	}
+}
+
+func TestErrorHandling(t *testing.T) {
+	testEquals(t, [][]byte{[]byte(`
+- task:
+    name: Test
+    run:
+      - shell: echo one; exit 3
+        ignore_error: true
+      - shell: echo "{{LAST.ERROR}}"
+`)}, "one\nexit status 3", nil)
+
+	testEquals(t, [][]byte{[]byte(`
+- task:
+    name: cleanup
+    shell: echo "cleanup {{ERROR}}"
+
+- task:
+    name: fails
+    run:
+      - shell: echo step
+      - shell: exit 4
+    on_error:
+      - shell: echo "failed {{LAST.ERROR}}"
+    finally: cleanup
+
+- task:
+    name: Test
+    run:
+      - task: fails
+        ignore_error: true
+      - shell: echo done
+`)}, "step\nfailed exit status 4\ncleanup exit status 4\ndone", nil, "Test")
+}
+
+func TestContinueOnError(t *testing.T) {
+	d, err := newTmpdir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(d.dir)
+
+	n, err := d.addFile([]byte(`
+- task:
+    name: Test
+    continue_on_error: true
+    run:
+      - shell: exit 1
+      - shell: echo two
+      - shell: exit 3
+    finally:
+      - shell: echo "finally"
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := rt(n, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.Run("Test")
+
+	if err == nil || err.Error() != "2 steps failed: exit status 1; exit status 3" {
+		t.Fatalf("Expected aggregated error, found %v", err)
+	}
+
+	if code, ok := exitCode(err); !ok || code != 1 {
+		t.Fatalf("Expected exit code of the first failure, found %d", code)
+	}
+
+	if out := strings.TrimSpace(r.Out().(*bytes.Buffer).String()); out != "two\nfinally" {
+		t.Fatalf("Expecting two finally, found %q", out)
+	}
+}
+
+func TestConditions(t *testing.T) {
+	testEquals(t, [][]byte{[]byte(`
+- set:
+    env: prod
+    count: 10
+
+- task:
+    name: prod-only
+    if: "{{env}} == prod"
+    shell: echo prod
+
+- task:
+    name: stage-only
+    params:
+      - name: stage
+        default: staging
+    if: "{{stage}} == staging"
+    shell: echo staging
+
+- task:
+    name: Test
+    run:
+      - prod-only
+      - stage-only
+      - shell: echo "hello world"
+      - shell: echo matched
+        if: '{{LAST.OUT}} == "hello world" && ({{count}} > 9 || !{{env}})'
+      - shell: echo skipped
+        unless: "{{count}} >= 10"
+      - shell: echo "{{#LAST.SKIPPED}}was skipped{{/LAST.SKIPPED}}"
+      - shell: echo never
+        if: "{{missing}}"
+      - shell: echo last
+        if: "{{env}} != dev"
+        unless: "false"
+`)}, "prod\nstaging\nhello world\nmatched\nwas skipped\nlast", nil, "Test")
+}
+
+func TestEach(t *testing.T) {
+	testEquals(t, [][]byte{[]byte(`
+- set:
+    targets:
+      - linux
+      - darwin
+    ports:
+      web: 80
+      db: 5432
+
+- task:
+    name: build
+    shell: echo "building {{target}}"
+
+- task:
+    name: Test
+    run:
+      - shell: echo "{{INDEX}}:{{ITEM}}"
+        each: "{{targets}}"
+      - shell: echo "{{KEY}}={{ITEM}}"
+        each: "{{ports}}"
+      - task: build
+        args:
+          target: "{{ITEM}}"
+        each: [arm, x86]
+        var: builds
+      - shell: echo "{{builds.1.OUT}} {{TASKS.build.0.OUT}}"
+      - shell: echo "line {{ITEM}}"
+        each: "{{LAST.OUT}}"
+      - shell: echo skipped {{ITEM}}
+        each: "{{targets}}"
+        if: "{{ITEM}} != linux"
+`)}, "0:linux\n1:darwin\ndb=5432\nweb=80\nbuilding arm\nbuilding x86\nbuilding x86 building arm\nline building x86 building arm\nskipped darwin", nil, "Test")
+}
+
+func TestResultMetadata(t *testing.T) {
+	testEquals(t, [][]byte{[]byte(`
+- task:
+    name: Test
+    run:
+      - shell: exit 3
+        ignore_error: true
+      - shell: echo "{{LAST.EXIT_CODE}} {{#LAST.SUCCESS}}ok{{/LAST.SUCCESS}}{{^LAST.SUCCESS}}failed{{/LAST.SUCCESS}}"
+      - shell: echo "{{LAST.EXIT_CODE}} {{LAST.SUCCESS}}"
+      - shell: sleep 0.1
+        var: slept
+      - shell: echo fast
+        if: "{{slept.DURATION}} < 0.1"
+      - shell: echo slow
+        if: "{{slept.DURATION}} >= 0.1 && {{slept.FINISHED_AT}} > {{slept.STARTED_AT}}"
+`)}, "3 failed\n0 true\nslow", nil, "Test")
+}
+
+func TestDir(t *testing.T) {
+	d, err := newTmpdir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(d.dir)
+
+	os.MkdirAll(d.dir+"/sub/inner", 0755)
+	ioutil.WriteFile(d.dir+"/sub/inner/file.txt", []byte("inner file\n"), 0644)
+
+	n, err := d.addFile([]byte(`
+- set:
+    sub: ` + d.dir + `/sub
+
+- task:
+    name: cat
+    dir: inner
+    shell: cat file.txt
+
+- task:
+    name: Test
+    dir: "{{sub}}"
+    run:
+      - shell: basename "$(pwd)"
+      - cat
+      - shell: cat file.txt
+        dir: inner
+      - shell: basename "$(pwd)"
+        dir: ..
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := rt(n, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Run("Test"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "sub\ninner file\ninner file\n" + d.dir[strings.LastIndex(d.dir, "/")+1:] + "\n"
+
+	if out := r.Out().(*bytes.Buffer).String(); out != expected {
+		t.Fatalf("Expected %#v, got %#v", expected, out)
+	}
+}
+
+func TestConcurrentRuns(t *testing.T) {
+	d, err := newTmpdir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(d.dir)
+
+	n, err := d.addFile([]byte(`
+- task:
+    name: Test
+    run:
+      - shell: echo "{{greeting}}"
+      - shell: echo "{{LAST.OUT}}"
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := LoadRuntime(n, nil, &syncWriter{w: ioutil.Discard}, ioutil.Discard)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.SetVar("greeting", "hello")
+	errs := make(chan error)
+
+	for i := 0; i < 10; i++ {
+		go func() {
+			errs <- r.Run("Test")
+		}()
+	}
+
+	if err := r.load(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	d, err := newTmpdir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(d.dir)
+
+	os.MkdirAll(d.dir+"/proj/lib", 0755)
+
+	ioutil.WriteFile(d.dir+"/proj/lib/Taskies", []byte(`
+- shell: basename "$(pwd)"
+`), 0644)
+
+	ioutil.WriteFile(d.dir+"/proj/Taskies", []byte(`
+- shell: basename "$(pwd)"
+- include: { lib: ./lib/Taskies }
+`), 0644)
+
+	r, err := rt(d.dir+"/proj/Taskies", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out := r.Out().(*bytes.Buffer).String(); out != "proj\nlib\n" {
+		t.Fatalf("Expected run items to run from the directory of their file, got %#v", out)
+	}
+}
+
+func TestIncludeWorkDir(t *testing.T) {
+	d, err := newTmpdir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(d.dir)
+
+	os.MkdirAll(d.dir+"/svc/api", 0755)
+
+	ioutil.WriteFile(d.dir+"/svc/api/Taskies", []byte(`
+- task:
+    name: Where
+    shell: basename "$(pwd)"
+
+- task:
+    name: Up
+    dir: ..
+    shell: basename "$(pwd)"
+`), 0644)
+
+	n, err := d.addFile([]byte(`
+- include:
+    - { api: ./svc/api/Taskies }
+    - { path: ./svc/api/Taskies, as: same }
+
+- task:
+    name: Test
+    run:
+      - api.Where
+      - api.Up
+      - shell: basename "$(pwd)"
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := rt(n, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Run("Test"); err != nil {
+		t.Fatal(err)
+	}
+
+	pwd, _ := os.Getwd()
+	expected := "api\nsvc\n" + pwd[strings.LastIndex(pwd, "/")+1:] + "\n"
+
+	if out := r.Out().(*bytes.Buffer).String(); out != expected {
+		t.Fatalf("Expected %#v, got %#v", expected, out)
+	}
+
+	n, err = d.addFile([]byte(`
+- include:
+    - { path: ./svc/api/Taskies, as: api, chdir: false }
+
+- task:
+    name: Test
+    run: api.Where
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = rt(n, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Run("Test"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected = pwd[strings.LastIndex(pwd, "/")+1:] + "\n"
+
+	if out := r.Out().(*bytes.Buffer).String(); out != expected {
+		t.Fatalf("Expected %#v, got %#v", expected, out)
+	}
+}
+
+func TestProcessEnv(t *testing.T) {
+	testEquals(t, [][]byte{[]byte(`
+- env:
+    GREETING: hello
+    TARGET: world
+
+- set:
+    quoted: it's "quoted"
+
+- task:
+    name: greet
+    env:
+      TARGET: there
+    shell: echo "$GREETING $TARGET"
+
+- task:
+    name: Test
+    run:
+      - greet
+      - shell: echo "$GREETING $TARGET"
+      - shell: echo "$QUOTED"
+        env:
+          QUOTED: "{{quoted}}"
+      - shell: echo "[$TASKIES_QUOTED]"
+`)}, "hello there\nhello world\nit's \"quoted\"\n[]", nil, "Test")
+
+	d, err := newTmpdir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(d.dir)
+
+	n, err := d.addFile([]byte(`
+- set:
+    quoted: it's "quoted"
+    targets: [a, b]
+
+- task:
+    name: Test
+    run:
+      - shell: echo "$TASKIES_QUOTED $TASKIES_TARGETS_1"
+      - shell: echo "$TASKIES_LAST_OUT"
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := rt(n, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.ExportVars = true
+
+	if err := r.Run("Test"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "it's \"quoted\" b\nit's \"quoted\" b\n"
+
+	if out := r.Out().(*bytes.Buffer).String(); out != expected {
+		t.Fatalf("Expected %#v, got %#v", expected, out)
+	}
+}
+
+func TestInterpreter(t *testing.T) {
+	testEquals(t, [][]byte{[]byte(`
+- interpreter: bash -eu -c
+
+- set:
+    name: "world; echo injected"
+
+- task:
+    name: arrays
+    shell: a=(x y z); echo "${a[1]}"
+
+- task:
+    name: plain
+    interpreter: [sh, -c]
+    shell: echo "$0"
+
+- task:
+    name: Test
+    run:
+      - arrays
+      - plain
+      - exec: [echo, "hello {{name}}"]
+      - script: |
+          set -- a b
+          echo "script $# ${BASH_VERSION:+bash}"
+      - script: |
+          echo "from sh"
+        interpreter: sh
+      - shell: echo "$UNSET_VAR"
+        ignore_error: true
+      - shell: echo "{{#LAST.ERROR}}strict{{/LAST.ERROR}}"
+`)}, "y\nsh\nhello world; echo injected\nscript 2 bash\nfrom sh\nstrict", nil, "Test")
+}
+
+func TestBuiltins(t *testing.T) {
+	d, err := newTmpdir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(d.dir)
+
+	n, err := d.addFile([]byte(`
+- set:
+    name: world
+
+- task:
+    name: Test
+    dir: ` + d.dir + `
+    run:
+      - mkdir: [dist/a, dist/b]
+      - write:
+          path: src/hello.txt
+          content: "hello {{name}}"
+      - write:
+          path: src/hello.txt
+          content: "!"
+          append: true
+      - write: { path: src/other.txt, content: other }
+      - copy: { src: "src/*.txt", dest: dist/a }
+      - copy: { src: src, dest: dist/src }
+      - move: { src: dist/a/other.txt, dest: dist/b/moved.txt }
+      - remove: "dist/a/*"
+      - shell: cat dist/src/hello.txt dist/b/moved.txt; ls dist/a | wc -l
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := rt(n, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Run("Test"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "mkdir dist/a\nmkdir dist/b\nwrite src/hello.txt\nwrite src/hello.txt\nwrite src/other.txt\n" +
+		"copy src/hello.txt -> dist/a/hello.txt\ncopy src/other.txt -> dist/a/other.txt\n" +
+		"copy src -> dist/src\nmove dist/a/other.txt -> dist/b/moved.txt\nremove dist/a/hello.txt\n" +
+		"hello world!other"
+
+	if out := r.Out().(*bytes.Buffer).String(); !strings.HasPrefix(out, expected) || strings.TrimSpace(out[len(expected):]) != "0" {
+		t.Fatalf("Expected %#v, got %#v", expected+"0", out)
+	}
+
+	n, err = d.addFile([]byte(`
+- task:
+    name: Test
+    dir: ` + d.dir + `
+    copy: { src: missing.txt, dest: dist }
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = rt(n, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.Run("Test")
+	fe, ok := err.(*FileError)
+
+	if !ok || fe.Op != "copy" || fe.Path != "missing.txt" || !os.IsNotExist(fe.Err) {
+		t.Fatalf("Expected a copy FileError, got %#v", err)
+	}
+}
+
+func TestRemoveGuards(t *testing.T) {
+	d, err := newTmpdir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(d.dir)
+
+	for _, path := range []string{`"{{unset}}/"`, `"{{unset}}/*"`, `"{{unset}}"`, `".."`} {
+		n, err := d.addFile([]byte(`
+- task:
+    name: Test
+    dir: ` + d.dir + `
+    remove: ` + path + `
+`))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := rt(n, nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// a dry run so a broken guard can't remove anything
+		r.DryRun = true
+		err = r.Run("Test")
+
+		var fe *FileError
+
+		if !errors.As(err, &fe) || fe.Op != "remove" || fe.Err != unsafeRemove {
+			t.Fatalf("Expected remove %s to be refused, got %v", path, err)
+		}
+
+		if out := r.Out().(*bytes.Buffer).String(); strings.Contains(out, "remove") {
+			t.Fatalf("Expected nothing to be removed for %s, got %#v", path, out)
+		}
+	}
+}
+
+func TestTransferGuards(t *testing.T) {
+	d, err := newTmpdir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(d.dir)
+
+	work := d.dir + "/work"
+	os.MkdirAll(work+"/src", 0755)
+	ioutil.WriteFile(work+"/src/a.txt", []byte("a"), 0644)
+
+	for _, args := range []string{
+		`copy: { src: ., dest: backup/ }`,
+		`copy: { src: src, dest: src/nested }`,
+		`copy: { src: src/a.txt, dest: src/a.txt }`,
+		`move: { src: src, dest: src/nested }`,
+	} {
+		n, err := d.addFile([]byte(`
+- task:
+    name: Test
+    dir: ` + work + `
+    ` + args + `
+`))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := rt(n, nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = r.Run("Test")
+
+		var fe *FileError
+
+		if !errors.As(err, &fe) || fe.Err != unsafeTransfer {
+			t.Fatalf("Expected %s to be refused, got %v", args, err)
+		}
+
+		for _, path := range []string{work + "/backup", work + "/src/nested"} {
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Fatalf("Expected %s not to exist after %s", path, args)
+			}
+		}
+
+		if b, err := ioutil.ReadFile(work + "/src/a.txt"); err != nil || string(b) != "a" {
+			t.Fatalf("Expected src/a.txt to be left alone by %s, got %#v, %v", args, string(b), err)
+		}
+	}
+}
+
+func TestTemplateTask(t *testing.T) {
+	d, err := newTmpdir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(d.dir)
+
+	os.MkdirAll(d.dir+"/scaffold/{{name}}", 0755)
+	ioutil.WriteFile(d.dir+"/scaffold/{{name}}/{{name}}.conf", []byte("name={{name}}\nport={{port}}\n"), 0644)
+	ioutil.WriteFile(d.dir+"/app.conf", []byte("{{#hosts}}host={{.}}\n{{/hosts}}"), 0644)
+
+	n, err := d.addFile([]byte(`
+- set:
+    name: api
+    hosts: [a, b]
+
+- task:
+    name: Test
+    dir: ` + d.dir + `
+    run:
+      - template:
+          src: scaffold
+          dest: out
+          vars:
+            port: 8080
+      - template: { src: app.conf, dest: out/ }
+      - shell: cat out/api/api.conf out/app.conf
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := rt(n, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Run("Test"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "template scaffold/{{name}}/{{name}}.conf -> out/api/api.conf\n" +
+		"template app.conf -> out/app.conf\n" +
+		"name=api\nport=8080\nhost=a\nhost=b\n"
+
+	if out := r.Out().(*bytes.Buffer).String(); out != expected {
+		t.Fatalf("Expected %#v, got %#v", expected, out)
+	}
+}
+
+func TestIncludeGlob(t *testing.T) {
+	d, err := newTmpdir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(d.dir)
+
+	os.MkdirAll(d.dir+"/tasks", 0755)
+	os.MkdirAll(d.dir+"/services/api", 0755)
+	os.MkdirAll(d.dir+"/services/docs", 0755)
+
+	for _, name := range []string{"build", "lint"} {
+		ioutil.WriteFile(d.dir+"/tasks/"+name+".yml", []byte(`
+- task:
+    name: Hello
+    shell: echo `+name+`
+`), 0644)
+	}
+
+	ioutil.WriteFile(d.dir+"/services/api/Taskies", []byte(`
+- task:
+    name: Hello
+    shell: basename "$(pwd)"
+`), 0644)
+
+	n, err := d.addFile([]byte(`
+- include:
+    - ./tasks/*.yml
+    - { svc: ./services/* }
+    - { api: ./services/api }
+
+- task:
+    name: Test
+    run:
+      - build.Hello
+      - lint.Hello
+      - svc.api.Hello
+      - api.Hello
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := rt(n, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Run("Test"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "build\nlint\napi\napi\n"
+
+	if out := r.Out().(*bytes.Buffer).String(); out != expected {
+		t.Fatalf("Expected %#v, got %#v", expected, out)
+	}
+}
+
+func TestIncludeCycle(t *testing.T) {
+	d, err := newTmpdir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(d.dir)
+
+	ioutil.WriteFile(d.dir+"/a.yml", []byte(`
+- include: { b: ./b.yml }
+`), 0644)
+
+	ioutil.WriteFile(d.dir+"/b.yml", []byte(`
+- include: { a: ./a.yml }
+`), 0644)
+
+	ioutil.WriteFile(d.dir+"/c.yml", []byte(`
+- include: { b: ./b.yml }
+`), 0644)
+
+	_, err = rt(d.dir+"/a.yml", nil)
+	expected := d.dir + "/b.yml:2:3: Include cycle detected: " + d.dir + "/a.yml -> " + d.dir + "/b.yml -> " + d.dir + "/a.yml"
+
+	if err == nil || err.Error() != expected {
+		t.Fatalf("Expected %#v, got %v", expected, err)
+	}
+
+	_, err = rt(d.dir+"/c.yml", nil)
+	expected = d.dir + "/a.yml:2:3: Include cycle detected: " + d.dir + "/b.yml -> " + d.dir + "/a.yml -> " + d.dir + "/b.yml"
+
+	if err == nil || err.Error() != expected {
+		t.Fatalf("Expected %#v, got %v", expected, err)
+	}
+}
+
+func TestQualifiedTasks(t *testing.T) {
+	d, err := newTmpdir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(d.dir)
+
+	os.MkdirAll(d.dir+"/lib", 0755)
+
+	ioutil.WriteFile(d.dir+"/lib/Taskies", []byte(`
+- task:
+    name: Inner
+    shell: echo inner
+`), 0644)
+
+	other, _ := d.addFile([]byte(`
+- include: { lib: ./lib }
+
+- task:
+    name: Hello
+    shell: echo hello
+
+- task:
+    name: private
+    shell: echo private
+`))
+
+	n, err := d.addFile([]byte(`
+- include: { other: ./` + other[strings.LastIndex(other, "/")+1:] + ` }
+
+- task:
+    name: Test
+    shell: echo test
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := rt(n, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"other.Hello", "other:Hello", "other.lib.Inner", "other:lib:Inner"} {
+		if err := r.Run(name); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+	}
+
+	for _, name := range []string{"other.private", "other.Missing", "missing.Hello"} {
+		if err := r.Run(name); err != MissingTask {
+			t.Fatalf("Expected MissingTask for %s, got %v", name, err)
+		}
+	}
+
+	if out := r.Out().(*bytes.Buffer).String(); out != "hello\nhello\ninner\ninner\n" {
+		t.Fatalf("Unexpected output %#v", out)
+	}
+
+	if tasks := strings.Join(r.Tasks(), " "); tasks != "Test other.Hello other.lib.Inner" {
+		t.Fatalf("Unexpected tasks %#v", tasks)
+	}
+}
+
+func TestTaskInfos(t *testing.T) {
+	d, err := newTmpdir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(d.dir)
+
+	os.MkdirAll(d.dir+"/lib", 0755)
+
+	ioutil.WriteFile(d.dir+"/lib/Taskies", []byte(`
+- task:
+    name: Inner
+    description: inner task
+    shell: echo inner
+`), 0644)
+
+	n, err := d.addFile([]byte(`
+- include: { lib: ./lib }
+
+- task:
+    name: Build
+    description: build it
+    deps: lib.Inner
+    params:
+      - name: target
+        required: true
+    pipe:
+      - shell: echo build
+      - task: lib.Inner
+        args: { a: b }
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := rt(n, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	infos := r.TaskInfos()
+
+	if len(infos) != 2 {
+		t.Fatalf("Expected 2 tasks, found %d", len(infos))
+	}
+
+	build, inner := infos[0], infos[1]
+
+	if build.Name != "Build" || build.Namespace != "" || build.File != n || build.Line != 4 ||
+		len(build.Params) != 1 || build.Params[0].Name != "target" || !build.Params[0].Required ||
+		len(build.Deps) != 1 || build.Deps[0] != "lib.Inner" {
+		t.Fatalf("Unexpected info %#v", build)
+	}
+
+	s := build.Structure
+
+	if s.Type != "pipe" || len(s.Steps) != 2 || s.Steps[0].Command != "echo build" || s.Steps[0].Line != 12 ||
+		s.Steps[1].Type != "task" || s.Steps[1].Task != "lib.Inner" || s.Steps[1].Line != 13 {
+		t.Fatalf("Unexpected structure %#v", s)
+	}
+
+	if inner.Name != "lib.Inner" || inner.Namespace != "lib" || inner.Description != "inner task" ||
+		inner.File != d.dir+"/lib/Taskies" || inner.Line != 2 || inner.Structure.Type != "shell" {
+		t.Fatalf("Unexpected info %#v", inner)
+	}
+}
+
+func TestMissingTaskPosition(t *testing.T) {
+	d, err := newTmpdir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(d.dir)
+
+	n, _ := d.addFile([]byte(`
+- task:
+    name: Build
+    run:
+      - shell: echo build
+      - compile
+`))
+
+	_, err = rt(n, nil)
+
+	if expected := n + `:6:9: task "Build" references unknown task "compile"`; err == nil || err.Error() != expected {
+		t.Fatalf("Expected %#v, got %v", expected, err)
+	}
+
+	n, _ = d.addFile([]byte(`
+- task:
+    name: Build
+    deps: [compile]
+    shell: echo build
+`))
+
+	_, err = rt(n, nil)
+
+	if expected := n + `:4:5: task "Build" depends on unknown task "compile"`; err == nil || err.Error() != expected {
+		t.Fatalf("Expected %#v, got %v", expected, err)
+	}
+}
+
+func TestStrictTemplates(t *testing.T) {
+	d, err := newTmpdir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(d.dir)
+
+	n, _ := d.addFile([]byte(`
+- task:
+    name: Clean
+    shell: echo "rm -rf {{dir}}/"
+
+- task:
+    name: Greet
+    shell: echo "hello{{name?}}"
+`))
+
+	r, err := rt(n, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Run("Clean"); err != nil {
+		t.Fatalf("Expected lenient templates by default, got %s", err)
+	}
+
+	r.Strict = true
+	err = r.Run("Clean")
+
+	var te *TemplateError
+
+	if !errors.As(err, &te) || te.Var != "dir" || te.Task != "Clean" {
+		t.Fatalf("Expected undefined var \"dir\" in task \"Clean\", got %v", err)
+	}
+
+	if expected := `Undefined template var "dir" in task "Clean"`; err.Error() != expected {
+		t.Fatalf("Expected %#v, got %#v", expected, err.Error())
+	}
+
+	if err := r.Run("Greet"); err != nil {
+		t.Fatalf("Expected optional var to render empty, got %s", err)
+	}
+
+	if out := r.Out().(*bytes.Buffer).String(); out != "rm -rf /\nhello\n" {
+		t.Fatalf("Expected %#v, got %#v", "rm -rf /\nhello\n", out)
+	}
+
+	n, _ = d.addFile([]byte(`
+- task:
+    name: Lenient
+    shell: echo "{{missing}}"
+
+- strict: true
+
+- task:
+    name: Deploy
+    run:
+      - shell: echo deploying
+      - shell: echo "{{target}}"
+
+- task:
+    name: Hash
+    sources: "{{srcdir}}/*"
+    shell: echo hashing
+
+- task:
+    name: Export
+    set:
+      url: "https://{{host}}/"
+    shell: echo exporting
+`))
+
+	r, err = rt(n, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Run("Lenient"); err != nil {
+		t.Fatalf("Expected tasks defined before strict to be lenient, got %s", err)
+	}
+
+	if err := r.Run("Deploy"); !errors.As(err, &te) || te.Var != "target" || te.Task != "Deploy" {
+		t.Fatalf("Expected undefined var \"target\" in task \"Deploy\", got %v", err)
+	}
+
+	if err := r.Run("Hash"); !errors.As(err, &te) || te.Var != "srcdir" || te.Task != "Hash" {
+		t.Fatalf("Expected undefined var \"srcdir\" in task \"Hash\", got %v", err)
+	}
+
+	if err := r.Run("Export"); !errors.As(err, &te) || te.Var != "host" || te.Task != "Export" {
+		t.Fatalf("Expected undefined var \"host\" in task \"Export\", got %v", err)
+	}
+
+	r.SetVar("target", "prod")
+
+	if err := r.Run("Deploy"); err != nil {
+		t.Fatal(err)
+	}
+}
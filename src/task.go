@@ -1,7 +1,9 @@
 package src
 
 import (
+	"context"
 	"io"
+	"sync"
 )
 
 type Task interface {
@@ -19,39 +21,66 @@ type RunContext interface {
 	Out() io.Writer
 	Err() io.Writer
 	Run(Task) error
+	RunOnce(Task) error
 	Clone(io.Reader, io.Writer, io.Writer, *Env) RunContext
+	WithContext(context.Context) RunContext
+	Context() context.Context
+	WithDir(string) RunContext
+	Dir() string
+	WithEnviron([]string) RunContext
+	Environ() []string
+	WithInterpreter([]string) RunContext
+	Interpreter() []string
+	WithStrict(bool) RunContext
+	Strict() bool
 	Env() *Env
+	Runtime() *Runtime
+	Depth() int
 }
 
-type context struct {
-	in    io.Reader
-	out   io.Writer
-	err   io.Writer
-	env   *Env
-	runfn func(RunContext, Task) error
+type runContext struct {
+	in      io.Reader
+	out     io.Writer
+	err     io.Writer
+	env     *Env
+	runfn   func(RunContext, Task) error
+	once    *onceSet
+	ctx     context.Context
+	rt      *Runtime
+	depth   int
+	dir     string
+	environ []string
+	interp  []string
+	strict  bool
 }
 
-func (c *context) Env() *Env {
+func (c *runContext) Env() *Env {
 	return c.env
 }
 
-func (c *context) In() io.Reader {
+func (c *runContext) In() io.Reader {
 	return c.in
 }
 
-func (c *context) Out() io.Writer {
+func (c *runContext) Out() io.Writer {
 	return c.out
 }
 
-func (c *context) Err() io.Writer {
+func (c *runContext) Err() io.Writer {
 	return c.err
 }
 
-func (c *context) Run(t Task) error {
+func (c *runContext) Run(t Task) error {
 	return c.runfn(c, t)
 }
 
-func (c *context) Clone(in io.Reader, out io.Writer, err io.Writer, env *Env) RunContext {
+func (c *runContext) RunOnce(t Task) error {
+	return c.once.do(t, func() error {
+		return c.Run(t)
+	})
+}
+
+func (c *runContext) Clone(in io.Reader, out io.Writer, err io.Writer, env *Env) RunContext {
 	if in == nil {
 		in = c.in
 	}
@@ -68,11 +97,140 @@ func (c *context) Clone(in io.Reader, out io.Writer, err io.Writer, env *Env) Ru
 		env = c.env
 	}
 
-	return &context{
-		in:    in,
-		out:   out,
-		err:   err,
-		runfn: c.runfn,
-		env:   env,
+	return &runContext{
+		in:      in,
+		out:     out,
+		err:     err,
+		runfn:   c.runfn,
+		env:     env,
+		once:    c.once,
+		ctx:     c.ctx,
+		rt:      c.rt,
+		depth:   c.depth,
+		dir:     c.dir,
+		environ: c.environ,
+		interp:  c.interp,
+		strict:  c.strict,
+	}
+}
+
+// Returns a copy of the run context which is cancelled along with ctx
+func (c *runContext) WithContext(ctx context.Context) RunContext {
+	c2 := c.Clone(nil, nil, nil, nil).(*runContext)
+	c2.ctx = ctx
+
+	return c2
+}
+
+func (c *runContext) Context() context.Context {
+	return c.ctx
+}
+
+// Returns a copy of the run context whose commands run in dir. A
+// relative dir is taken relative to the current one
+func (c *runContext) WithDir(dir string) RunContext {
+	c2 := c.Clone(nil, nil, nil, nil).(*runContext)
+	c2.dir = joinDir(c.dir, dir)
+
+	return c2
+}
+
+// Directory commands run in, "" for the working directory of the process
+func (c *runContext) Dir() string {
+	return c.dir
+}
+
+// Returns a copy of the run context whose commands get the "KEY=value"
+// entries of environ added to their environment
+func (c *runContext) WithEnviron(environ []string) RunContext {
+	c2 := c.Clone(nil, nil, nil, nil).(*runContext)
+	c2.environ = append(append(make([]string, 0, len(c.environ)+len(environ)), c.environ...), environ...)
+
+	return c2
+}
+
+// "KEY=value" entries added to the environment of commands
+func (c *runContext) Environ() []string {
+	return c.environ
+}
+
+// Returns a copy of the run context whose shell steps and scripts are
+// run with interp rather than "sh -c"
+func (c *runContext) WithInterpreter(interp []string) RunContext {
+	c2 := c.Clone(nil, nil, nil, nil).(*runContext)
+	c2.interp = interp
+
+	return c2
+}
+
+// Command and args shell steps are run with, the step itself being
+// passed as the last arg
+func (c *runContext) Interpreter() []string {
+	if c.interp == nil {
+		return defaultInterpreter
+	}
+
+	return c.interp
+}
+
+// Returns a copy of the run context whose templates fail on vars which
+// aren't set. Strict mode can't be turned off again for nested tasks
+func (c *runContext) WithStrict(strict bool) RunContext {
+	c2 := c.Clone(nil, nil, nil, nil).(*runContext)
+	c2.strict = c.strict || strict
+
+	return c2
+}
+
+// Whether templates fail on vars which aren't set, unless marked optional
+// like {{var?}}
+func (c *runContext) Strict() bool {
+	return c.strict || (c.rt != nil && c.rt.Strict)
+}
+
+func (c *runContext) Runtime() *Runtime {
+	return c.rt
+}
+
+// Number of named tasks the context is nested in
+func (c *runContext) Depth() int {
+	return c.depth
+}
+
+func newOnceSet() *onceSet {
+	return &onceSet{
+		runs: make(map[Task]*onceRun),
+	}
+}
+
+// tracks which tasks already ran during a single Runtime.Run call so
+// that shared dependencies are only executed once
+type onceSet struct {
+	l    sync.Mutex
+	runs map[Task]*onceRun
+}
+
+type onceRun struct {
+	done chan bool
+	err  error
+}
+
+func (o *onceSet) do(t Task, fn func() error) error {
+	o.l.Lock()
+
+	if run, ok := o.runs[t]; ok {
+		o.l.Unlock()
+		<-run.done
+
+		return run.err
 	}
+
+	run := &onceRun{done: make(chan bool)}
+	o.runs[t] = run
+	o.l.Unlock()
+
+	run.err = fn()
+	close(run.done)
+
+	return run.err
 }
@@ -4,11 +4,39 @@ import (
 	"fmt"
 	"github.com/dimerica-industries/taskies/mustache"
 	"reflect"
+	"strings"
 )
 
+// Error returned in strict mode when a template looks up a var which
+// isn't set. Task is the name of the task whose template it is, if known
+type TemplateError struct {
+	Var  string
+	Task string
+}
+
+func (e *TemplateError) Error() string {
+	if e.Task == "" {
+		return fmt.Sprintf("Undefined template var \"%s\"", e.Var)
+	}
+
+	return fmt.Sprintf("Undefined template var \"%s\" in task \"%s\"", e.Var, e.Task)
+}
+
 func template(tmpl interface{}, e *Env) interface{} {
+	v, _ := renderValue(tmpl, e, false)
+	return v
+}
+
+// render tmpl like template() against the env of r. In strict mode a
+// template looking up a var which isn't set is an error, unless the tag
+// marks it optional like {{var?}}
+func render(r RunContext, tmpl interface{}) (interface{}, error) {
+	return renderValue(tmpl, r.Env(), r.Strict())
+}
+
+func renderValue(tmpl interface{}, e *Env, strict bool) (interface{}, error) {
 	if _, ok := tmpl.(*varSet); ok {
-		return tmpl
+		return tmpl, nil
 	}
 
 	var str string
@@ -16,49 +44,92 @@ func template(tmpl interface{}, e *Env) interface{} {
 
 	switch r.Kind() {
 	case reflect.Interface:
-		return template(r.Elem().Interface(), e)
+		return renderValue(r.Elem().Interface(), e, strict)
 	case reflect.Map:
 		m := make(map[string]interface{})
 		keys := r.MapKeys()
 
 		for _, k := range keys {
 			v := r.MapIndex(k)
-			tk := template(k, e).(string)
-			tv := template(v.Interface(), e)
+			tk, err := renderValue(k, e, strict)
+
+			if err != nil {
+				return nil, err
+			}
 
-			m[tk] = tv
+			tv, err := renderValue(v.Interface(), e, strict)
+
+			if err != nil {
+				return nil, err
+			}
+
+			m[tk.(string)] = tv
 		}
 
-		return m
+		return m, nil
 	case reflect.Slice:
 		l := r.Len()
 		sl := make([]interface{}, l)
 
 		for i := 0; i < l; i++ {
-			v := r.Index(i).Elem().Interface()
-			sl[i] = template(v, e)
+			v, err := renderValue(r.Index(i).Elem().Interface(), e, strict)
+
+			if err != nil {
+				return nil, err
+			}
+
+			sl[i] = v
 		}
 
-		return sl
+		return sl, nil
+	case reflect.Bool, reflect.Int, reflect.Int64, reflect.Float64:
+		return tmpl, nil
 	default:
 		str = fmt.Sprintf("%v", tmpl)
 	}
 
-	out := mustache.Render(str, &finder{e})
+	t, err := mustache.ParseString(str)
+
+	if err != nil {
+		return err.Error(), nil
+	}
+
+	out, err := renderTemplate(t, e, strict)
 
 	Debugf("[TEMPLATE] [ENV=%s] [before=%s] [after=%s]", e.Id(), str, out)
 
-	return out
+	return out, err
+}
+
+func renderTemplate(t *mustache.Template, e *Env, strict bool) (string, error) {
+	f := &finder{env: e}
+	out := t.Render(f)
+
+	if strict && len(f.missing) > 0 {
+		return "", &TemplateError{Var: f.missing[0]}
+	}
+
+	return out, nil
 }
 
 type finder struct {
 	env *Env
+
+	// names looked up which aren't set and aren't optional
+	missing []string
 }
 
 func (f *finder) Lookup(name string) reflect.Value {
+	optional := strings.HasSuffix(name, "?")
+	name = strings.TrimSuffix(name, "?")
+
 	if v := f.env.GetVar(name); v != nil {
 		return reflect.ValueOf(v)
 	}
 
+	if !optional && name != "." {
+		f.missing = append(f.missing, name)
+	}
+
 	return reflect.Value{}
 }
@@ -1,9 +1,14 @@
 package src
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
 )
 
 // Run the specified function only if the DEBUG environment
@@ -37,24 +42,75 @@ func Debugf(args ...interface{}) {
 	})
 }
 
-func inDir(path string, fn func() error) error {
-	pwd, err := os.Getwd()
+// path of dir relative to base, base being "" for the working directory
+func joinDir(base, dir string) string {
+	if dir == "" {
+		return base
+	}
 
-	if err != nil {
-		return err
+	if base == "" || filepath.IsAbs(dir) {
+		return dir
 	}
 
-	Debugf("[CHDIR] %s", path)
-	err = os.Chdir(path)
+	return filepath.Join(base, dir)
+}
+
+// path relative to the working directory when it is below it
+func displayPath(path string) string {
+	wd, err := os.Getwd()
 
 	if err != nil {
-		return err
+		return path
+	}
+
+	rel, err := filepath.Rel(wd, path)
+
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+
+	return rel
+}
+
+// io.Writer which can be shared by tasks running concurrently
+type syncWriter struct {
+	l sync.Mutex
+	w io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.l.Lock()
+	defer w.l.Unlock()
+
+	return w.w.Write(p)
+}
+
+// join args into a string that sh reads back as the same args
+func shellQuote(args []string) string {
+	quoted := make([]string, len(args))
+
+	for i, arg := range args {
+		if arg != "" && strings.IndexFunc(arg, needsQuote) < 0 {
+			quoted[i] = arg
+		} else {
+			quoted[i] = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+		}
 	}
 
-	if err = fn(); err != nil {
-		return err
+	return strings.Join(quoted, " ")
+}
+
+func needsQuote(r rune) bool {
+	return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./=:,+@%", r))
+}
+
+// exit code of the command which failed with err
+func exitCode(err error) (int, bool) {
+	var ee *exec.ExitError
+
+	if errors.As(err, &ee) {
+		return ee.ExitCode(), true
 	}
 
-	Debugf("[CHDIR] %s", pwd)
-	return os.Chdir(pwd)
+	return 0, false
 }
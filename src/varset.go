@@ -57,13 +57,14 @@ func (e *varSet) get(k string) interface{} {
 
 			cur = v.Interface()
 		case kind == reflect.Slice:
-			i, _ := strconv.Atoi(p)
-			v := r.Index(i)
+			i, err := strconv.Atoi(p)
 
-			if !v.IsValid() {
+			if err != nil || i < 0 || i >= r.Len() {
 				return nil
 			}
 
+			v := r.Index(i)
+
 			cur = v.Interface()
 		default:
 			return nil
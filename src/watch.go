@@ -0,0 +1,187 @@
+package src
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	watchInterval = 500 * time.Millisecond
+	watchDebounce = 200 * time.Millisecond
+)
+
+// Run a task, then keep polling the files it watches and run it again
+// whenever they change, each time in a fresh child of the root env. A run
+// still in progress is cancelled before the next one starts, and the
+// runtime is reloaded first when one of its Taskies files changed. Watch
+// returns once ctx is cancelled
+func (r *Runtime) Watch(ctx context.Context, task string) error {
+	t := r.GetTask(task)
+
+	if t == nil {
+		return MissingTask
+	}
+
+	globs := watchGlobs(t)
+
+	if len(globs) == 0 {
+		return fmt.Errorf("Task \"%s\" has no watch or sources globs", task)
+	}
+
+	var (
+		cancel context.CancelFunc
+		done   chan bool
+	)
+
+	start := func() {
+		var rctx context.Context
+
+		rctx, cancel = context.WithCancel(ctx)
+		done = make(chan bool)
+
+		// each run gets an env of its own so the results of earlier runs
+		// don't pile up in the root env
+		env := r.RootNs().RootEnv().Child()
+
+		go func(t Task, done chan bool) {
+			defer close(done)
+
+			if err := r.runWithContext(rctx, env, t); err != nil && rctx.Err() == nil {
+				fmt.Fprintf(r.Err(), "Error: %s\n", err)
+			}
+		}(t, done)
+	}
+
+	stop := func() {
+		cancel()
+		<-done
+	}
+
+	files := r.snapshot(globs)
+	defs := r.snapshot(r.loadedPaths())
+
+	start()
+
+	for {
+		select {
+		case <-ctx.Done():
+			stop()
+			return nil
+		case <-time.After(watchInterval):
+		}
+
+		files2 := r.snapshot(globs)
+		defs2 := r.snapshot(r.loadedPaths())
+
+		if files2.equal(files) && defs2.equal(defs) {
+			continue
+		}
+
+		// wait for the files to settle so a burst of writes triggers a
+		// single run
+		for {
+			select {
+			case <-ctx.Done():
+				stop()
+				return nil
+			case <-time.After(watchDebounce):
+			}
+
+			files3 := r.snapshot(globs)
+			defs3 := r.snapshot(r.loadedPaths())
+
+			if files3.equal(files2) && defs3.equal(defs2) {
+				break
+			}
+
+			files2, defs2 = files3, defs3
+		}
+
+		stop()
+
+		if !defs2.equal(defs) && r.path != "" {
+			Debugf("[RELOAD] %s", r.path)
+
+			if err := r.load(); err != nil {
+				fmt.Fprintf(r.Err(), "Error: %s\n", err)
+			} else if t2 := r.GetTask(task); t2 == nil {
+				fmt.Fprintf(r.Err(), "Error: %s\n", MissingTask)
+			} else {
+				t = t2
+				globs = watchGlobs(t)
+			}
+
+			files2 = r.snapshot(globs)
+			defs2 = r.snapshot(r.loadedPaths())
+		}
+
+		files, defs = files2, defs2
+
+		start()
+	}
+}
+
+// the globs a task is watched with, falling back to its sources, taken
+// relative to the dir of the task
+func watchGlobs(t Task) []string {
+	ot, ok := t.(*optionsTask)
+
+	if !ok {
+		return nil
+	}
+
+	globs := ot.opts.watch
+
+	if len(globs) == 0 {
+		globs = ot.opts.sources
+	}
+
+	joined := make([]string, len(globs))
+
+	for i, g := range globs {
+		joined[i] = joinDir(ot.opts.dir, g)
+	}
+
+	return joined
+}
+
+type fileState struct {
+	mod  time.Time
+	size int64
+}
+
+type snapshot map[string]fileState
+
+func (r *Runtime) snapshot(globs []string) snapshot {
+	s := make(snapshot)
+	files, err := expandGlobs(globs, "", r.newContext(context.Background(), r.RootNs().RootEnv()))
+
+	if err != nil {
+		Debugf("[WATCH] %s", err)
+		return s
+	}
+
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			s[file] = fileState{info.ModTime(), info.Size()}
+		}
+	}
+
+	return s
+}
+
+func (s snapshot) equal(s2 snapshot) bool {
+	if len(s) != len(s2) {
+		return false
+	}
+
+	for k, v := range s {
+		if v2, ok := s2[k]; !ok || v2 != v {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,110 @@
+package src
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type lockedBuffer struct {
+	l   sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	return b.buf.String()
+}
+
+func waitFor(t *testing.T, b *lockedBuffer, val string) {
+	deadline := time.Now().Add(5 * time.Second)
+
+	for time.Now().Before(deadline) {
+		if strings.Contains(b.String(), val) {
+			return
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	t.Fatalf("Expected output to contain %q, found %q", val, b.String())
+}
+
+func TestWatch(t *testing.T) {
+	d, err := newTmpdir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(d.dir)
+
+	src := d.dir + "/src.txt"
+	ioutil.WriteFile(src, []byte("one"), 0644)
+
+	tmpl := `
+- task:
+    name: Test
+    watch: %s/*.txt
+    shell: echo %s $(cat %s)
+`
+
+	n, err := d.addFile([]byte(fmt.Sprintf(tmpl, d.dir, "first", src)))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := &lockedBuffer{}
+	r, err := LoadRuntime(n, nil, out, out)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		done <- r.Watch(ctx, "Test")
+	}()
+
+	waitFor(t, out, "first one")
+
+	future := time.Now().Add(time.Hour)
+	ioutil.WriteFile(src, []byte("two"), 0644)
+	os.Chtimes(src, future, future)
+
+	waitFor(t, out, "first two")
+
+	ioutil.WriteFile(n, []byte(fmt.Sprintf(tmpl, d.dir, "second", src)), 0644)
+	os.Chtimes(n, future, future)
+
+	waitFor(t, out, "second two")
+
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// every run has an env of its own
+	if v := r.RootNs().RootEnv().GetVar("TASKS"); v != nil {
+		t.Fatalf("Expected no results in the root env, found %v", v)
+	}
+}
@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	taskies "github.com/dimerica-industries/taskies/src"
+	"launchpad.net/goyaml"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime/debug"
+	"strconv"
 	"strings"
+	"syscall"
 	"text/tabwriter"
 )
 
@@ -29,6 +35,21 @@ func main() {
 	file := flag.String("f", DEFAULT_FILE, "Location of the taskie file")
 	help := flag.Bool("h", false, "Show help")
 	list := flag.Bool("l", false, "List all available tasks")
+	format := flag.String("format", "", "Format of the task list, json or yaml for a machine readable one")
+	jobs := flag.Int("j", 0, "Maximum number of tasks a parallel block runs at once (0 for no limit)")
+	watch := flag.Bool("w", false, "Run the task again whenever the files it watches change")
+	exportVars := flag.Bool("export-vars", false, "Export every var to the environment of commands as TASKIES_*")
+	checkOnly := flag.Bool("check", false, "Check the taskie file for problems without running anything")
+	strict := flag.Bool("strict", false, "Fail tasks whose templates use vars which aren't set, unless marked optional like {{var?}}")
+	dryRun := false
+	flag.BoolVar(&dryRun, "n", false, "Print the commands a task would run without running them")
+	flag.BoolVar(&dryRun, "dry-run", false, "Same as -n")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <task> [-param value ...]\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s [-f file] -check\n\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
 
 	flag.Parse()
 
@@ -52,22 +73,28 @@ func main() {
 		panic(err)
 	}
 
+	if *checkOnly {
+		os.Exit(check(f))
+	}
+
 	rt, err := taskies.LoadRuntime(f, os.Stdin, os.Stdout, os.Stderr)
 
-	if err != nil {
+	if os.IsNotExist(err) {
 		taskies.Debugf(err)
 		panic("Cannot read " + *file)
 	}
 
+	if err != nil {
+		panic(err)
+	}
+
 	l := func() {
 		fmt.Printf("Available Tasks:\n")
 		w := new(tabwriter.Writer)
 		w.Init(os.Stdout, 0, 8, 0, '\t', 0)
 
-		ns := rt.RootNs()
-
-		for _, name := range ns.Tasks() {
-			t := ns.GetTask(name)
+		for _, name := range rt.Tasks() {
+			t := rt.GetTask(name)
 
 			fmt.Fprintf(w, "   %s\t%s\n", name, t.Description())
 		}
@@ -76,7 +103,12 @@ func main() {
 	}
 
 	if *list {
-		l()
+		if *format != "" {
+			listAs(rt, *format)
+		} else {
+			l()
+		}
+
 		os.Exit(0)
 	}
 
@@ -87,17 +119,78 @@ func main() {
 		os.Exit(1)
 	}
 
-	rt.Watcher = &watcher{1}
+	if !dryRun {
+		rt.Watcher = &watcher{1}
+	}
+
+	rt.Jobs = *jobs
+	rt.DryRun = dryRun
+	rt.ExportVars = *exportVars
+	rt.Strict = *strict
+
+	err = rt.SetArgs(task, nargs)
+
+	if err != nil {
+		panic(err)
+	}
+
+	// cancel the run on Ctrl-C or SIGTERM, killing any command running
+	// in its own process group along with it
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *watch {
+		err = rt.Watch(ctx, task)
+	} else {
+		err = rt.RunWithContext(ctx, task)
+	}
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+// print the problems found in file, returning the exit code
+func check(file string) int {
+	problems, hints := taskies.Check(file)
+
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+
+	// hints don't fail the check
+	for _, h := range hints {
+		fmt.Println(&taskies.SourceError{File: h.File, Line: h.Line, Col: h.Col, Err: fmt.Errorf("hint: %s", h.Err)})
+	}
 
-	for k, v := range nargs {
-		rt.RootNs().RootEnv().SetVar(k, v)
+	if len(problems) > 0 {
+		fmt.Printf("%d problem(s) found\n", len(problems))
+		return 1
 	}
 
-	err = rt.Run(task)
+	return 0
+}
+
+// print the description of every task as json or yaml
+func listAs(rt *taskies.Runtime, format string) {
+	var out []byte
+	var err error
+
+	switch format {
+	case "json":
+		out, err = json.MarshalIndent(rt.TaskInfos(), "", "  ")
+		out = append(out, '\n')
+	case "yaml":
+		out, err = goyaml.Marshal(rt.TaskInfos())
+	default:
+		err = fmt.Errorf("Unknown list format \"%s\", expected json or yaml", format)
+	}
 
 	if err != nil {
 		panic(err)
 	}
+
+	os.Stdout.Write(out)
 }
 
 type watcher struct {
@@ -122,35 +215,56 @@ func (w *watcher) AfterRun(r *taskies.Runtime, e *taskies.Env, t taskies.Task) c
 	return nil
 }
 
+func (w *watcher) Retry(r *taskies.Runtime, e *taskies.Env, t taskies.Task, attempt int, err error) chan bool {
+	if w.level > 0 {
+		name := t.Name()
+
+		if name == "" {
+			name = t.Type()
+		}
+
+		fmt.Fprintf(r.Err(), "\n\033[1m[Attempt %d of task %s failed: %s]\033[0m\n", attempt, name, err)
+	}
+
+	return nil
+}
+
+// parse task args given as -key value or -key=value. A key which isn't
+// followed by a value, such as a trailing -verbose, is set to "true"
 func parseArgs(args []string) map[string]string {
 	ret := make(map[string]string)
 
 	k := ""
 
 	for _, v := range args {
-
-		if k == "" {
-			if v[0] != '-' {
+		if k != "" {
+			// a negative number is a value, any other -arg a new key
+			if _, err := strconv.ParseFloat(v, 64); !strings.HasPrefix(v, "-") || err == nil {
+				ret[k] = v
+				k = ""
 				continue
 			}
 
-			for v[0] == '-' {
-				v = v[1:]
-			}
+			ret[k] = "true"
+			k = ""
+		}
 
-			k = v
-			i := strings.Index(k, "=")
+		if v == "" || v[0] != '-' {
+			continue
+		}
 
-			if i >= 0 {
-				ret[k[0:i]] = k[i+1:]
-			}
+		v = strings.TrimLeft(v, "-")
 
-			k = ""
-		} else {
-			ret[k] = v
-			k = ""
+		if i := strings.Index(v, "="); i >= 0 {
+			ret[v[0:i]] = v[i+1:]
+		} else if v != "" {
+			k = v
 		}
 	}
 
+	if k != "" {
+		ret[k] = "true"
+	}
+
 	return ret
 }